@@ -0,0 +1,354 @@
+package url
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// Values of the archive option, other than auto and none
+const (
+	archiveZip = "zip"
+	archiveTar = "tar"
+)
+
+var nonIdentifierRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// archiveFormat :: the archive format of a table URL, or "" if it is not an
+// archive. In auto mode this is decided by the URL extension, after any
+// compression extension, so that plain data URLs are not fetched twice.
+func archiveFormat(table tableConfig) (string, error) {
+	s_option := strings.ToLower(stringValue(table.Archive))
+	switch s_option {
+	case "none":
+		return "", nil
+	case archiveZip, archiveTar:
+		return s_option, nil
+	case "", "auto":
+	default:
+		return "", fmt.Errorf("archive must be one of auto, none, zip or tar, got %q", *table.Archive)
+	}
+
+	s_path := strings.ToLower(urlPath(stringValue(table.DataURL)))
+	s_ext := path.Ext(s_path)
+	switch s_ext {
+	case ".tgz", ".tbz2", ".txz":
+		return archiveTar, nil
+	}
+	if _, ok := compressionExtensions[s_ext]; ok {
+		s_ext = path.Ext(strings.TrimSuffix(s_path, s_ext))
+	}
+	switch s_ext {
+	case ".zip":
+		return archiveZip, nil
+	case ".tar":
+		return archiveTar, nil
+	}
+	return "", nil
+}
+
+// archiveTables :: expand a table whose URL is an archive into one table per
+// member file matching archive_members. Other tables are returned as is.
+func archiveTables(ctx context.Context, table tableConfig) ([]tableConfig, error) {
+
+	s_format, err := archiveFormat(table)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	if s_format == "" {
+		return []tableConfig{table}, nil
+	}
+
+	s_glob := "*"
+	if table.ArchiveMembers != nil && *table.ArchiveMembers != "" {
+		s_glob = *table.ArchiveMembers
+	}
+	if _, err := path.Match(s_glob, ""); err != nil {
+		return nil, fmt.Errorf("table %s: invalid archive_members pattern %q: %v", table.Name, s_glob, err)
+	}
+
+	var download *zipDownload
+	if s_format == archiveZip {
+		// the members are read from one download of the archive
+		download = &zipDownload{}
+	}
+	sa_members, err := listArchiveMembers(ctx, table, s_format, download)
+	if err != nil {
+		if download != nil {
+			download.remove()
+		}
+		return nil, err
+	}
+
+	var tables []tableConfig
+	sm_names := map[string]string{}
+	for _, s_member := range sa_members {
+		// skip metadata such as __MACOSX/._data.csv added by archivers
+		if strings.HasPrefix(path.Base(s_member), ".") || strings.HasPrefix(s_member, "__MACOSX/") {
+			continue
+		}
+		matched, _ := path.Match(s_glob, s_member)
+		if !matched {
+			matched, _ = path.Match(s_glob, path.Base(s_member))
+		}
+		if !matched {
+			continue
+		}
+		s_name := table.Name + "_" + memberTableName(s_member)
+		if s_other, ok := sm_names[s_name]; ok {
+			plugin.Logger(ctx).Warn("skipping archive member with the same table name as another", "table", s_name, "member", s_member, "other", s_other)
+			continue
+		}
+		sm_names[s_name] = s_member
+		member := table
+		member.Name = s_name
+		member.member = s_member
+		member.archive = s_format
+		member.download = download
+		tables = append(tables, member)
+	}
+	if len(tables) == 0 {
+		plugin.Logger(ctx).Warn("no archive members match archive_members", "table", table.Name, "archive_members", s_glob)
+		if download != nil {
+			download.remove()
+		}
+	}
+	return tables, nil
+}
+
+// memberTableName :: a table name suffix for an archive member, its path
+// without extensions in lower case with other characters replaced by "_"
+func memberTableName(s_member string) string {
+	s_name := strings.ToLower(s_member)
+	for path.Ext(s_name) != "" {
+		s_name = strings.TrimSuffix(s_name, path.Ext(s_name))
+	}
+	return strings.Trim(nonIdentifierRegex.ReplaceAllString(s_name, "_"), "_")
+}
+
+// listArchiveMembers :: the paths of the regular files in an archive
+func listArchiveMembers(ctx context.Context, table tableConfig, s_format string, download *zipDownload) ([]string, error) {
+
+	var sa_members []string
+	if s_format == archiveZip {
+		archive, err := download.open(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		defer archive.Close()
+		for _, member := range archive.File {
+			if !member.FileInfo().IsDir() {
+				sa_members = append(sa_members, member.Name)
+			}
+		}
+		return sa_members, nil
+	}
+
+	data, err := openURL(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	tr := tar.NewReader(data)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return sa_members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("table %s: reading tar archive %s: %v", table.Name, data.URL, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			sa_members = append(sa_members, header.Name)
+		}
+	}
+}
+
+// openTarMember :: the content of one member of a tar archive
+func openTarMember(data *fetchedData, s_member string) (*fetchedData, error) {
+
+	member := *data
+	member.Path = s_member
+	member.URL = data.URL + "#" + s_member
+	member.ContentType = ""
+	member.ContentEncoding = ""
+
+	tr := tar.NewReader(data)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in %s", s_member, data.URL)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar archive %s: %v", data.URL, err)
+		}
+		if header.Name == s_member {
+			member.ReadCloser = &readCloser{Reader: tr, closers: []io.Closer{data.ReadCloser}}
+			return &member, nil
+		}
+	}
+}
+
+// zipDownload is a zip archive saved to a temporary file, as zip needs random
+// access, and shared by the member tables while the schema is built, so that
+// the archive is downloaded once rather than for each member. Once removed,
+// each query downloads the archive anew, to a file removed when it is done.
+type zipDownload struct {
+	sync.Mutex
+	url     string
+	path    string
+	removed bool
+}
+
+// remove :: delete the saved archive, once the schema is built
+func (download *zipDownload) remove() {
+	download.Lock()
+	defer download.Unlock()
+
+	if download.path != "" {
+		os.Remove(download.path)
+		download.path = ""
+	}
+	download.removed = true
+}
+
+// zipArchive is an open zip archive saved to a file, which is removed on
+// Close unless it is shared
+type zipArchive struct {
+	*zip.Reader
+	file   *os.File
+	url    string
+	shared bool
+}
+
+func (archive *zipArchive) Close() error {
+	err := archive.file.Close()
+	if !archive.shared {
+		os.Remove(archive.file.Name())
+	}
+	return err
+}
+
+// open :: the shared archive, downloading it first if that has not been done
+// yet, or a download of its own after the shared one is removed or when
+// there is none. The caller must close the archive.
+func (download *zipDownload) open(ctx context.Context, table tableConfig) (*zipArchive, error) {
+
+	if download == nil {
+		return downloadZip(ctx, table)
+	}
+	download.Lock()
+	defer download.Unlock()
+
+	if download.removed {
+		return downloadZip(ctx, table)
+	}
+	if download.path != "" {
+		file, err := os.Open(download.path)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: reading zip archive %s: %v", table.Name, download.url, err)
+		}
+		return readZip(table, download.url, file, true)
+	}
+	archive, err := downloadZip(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	archive.shared = true
+	download.url = archive.url
+	download.path = archive.file.Name()
+	return archive, nil
+}
+
+// downloadZip :: save a zip archive to a temporary file, within max_bytes,
+// which fails unless max_bytes_policy is warn as a zip archive cannot be
+// read in part, its directory being at the end
+func downloadZip(ctx context.Context, table tableConfig) (*zipArchive, error) {
+
+	s_policy, err := maxBytesPolicy(table)
+	if err != nil {
+		return nil, err
+	}
+	source := table
+	source.member = ""
+	source.download = nil
+	data, err := openURL(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	file, err := os.CreateTemp("", "steampipe-url-*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	var reader io.Reader = data
+	i_max_bytes := maxBytes(table)
+	if i_max_bytes > 0 && s_policy != maxBytesWarn {
+		reader = io.LimitReader(data, i_max_bytes+1)
+	}
+	i_size, err := io.Copy(file, reader)
+	if err == nil && i_max_bytes > 0 && i_size > i_max_bytes {
+		if s_policy != maxBytesWarn {
+			err = fmt.Errorf("zip archive is larger than max_bytes of %d", i_max_bytes)
+		} else {
+			plugin.Logger(ctx).Warn("data is larger than max_bytes", "table", table.Name, "max_bytes", i_max_bytes, "bytes_read", i_size)
+		}
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("table %s: downloading %s: %v", table.Name, data.URL, err)
+	}
+	return readZip(table, data.URL, file, false)
+}
+
+// readZip :: open a saved archive, closing the file on failure
+func readZip(table tableConfig, s_url string, file *os.File, b_shared bool) (*zipArchive, error) {
+
+	archive := &zipArchive{file: file, url: s_url, shared: b_shared}
+	info, err := file.Stat()
+	if err == nil {
+		if archive.Reader, err = zip.NewReader(file, info.Size()); err == nil {
+			return archive, nil
+		}
+	}
+	archive.Close()
+	return nil, fmt.Errorf("table %s: reading zip archive %s: %v", table.Name, s_url, err)
+}
+
+// openZipMember :: the content of the zip archive member of a table
+func openZipMember(ctx context.Context, table tableConfig) (*fetchedData, error) {
+
+	archive, err := table.download.open(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range archive.File {
+		if entry.Name != table.member {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			archive.Close()
+			return nil, fmt.Errorf("table %s: opening %s in %s: %v", table.Name, table.member, archive.url, err)
+		}
+		return &fetchedData{
+			ReadCloser: &readCloser{Reader: rc, closers: []io.Closer{rc, archive}},
+			URL:        archive.url + "#" + table.member,
+			Path:       table.member,
+		}, nil
+	}
+	archive.Close()
+	return nil, fmt.Errorf("table %s: %s not found in %s", table.Name, table.member, archive.url)
+}
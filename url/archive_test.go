@@ -0,0 +1,125 @@
+package url
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// archiveCases are the members of a zip archive and the tables they give,
+// with metadata, directories and members whose table name is taken by an
+// earlier member skipped
+var archiveCases = []struct {
+	members []string
+	glob    string
+	tables  []string
+}{
+	{[]string{"sales.csv"}, "", []string{"t_sales"}},
+	{[]string{"2024/Sales.csv", "2024/costs.tsv", "readme.txt"}, "*.csv", []string{"t_2024_sales"}},
+	{[]string{"a.csv", "sub/", "sub/a.csv", "__MACOSX/._a.csv", ".hidden.csv"}, "", []string{"t_a", "t_sub_a"}},
+	{[]string{"sales.csv", "SALES.CSV", "sales.csv.gz"}, "", []string{"t_sales"}},
+}
+
+// writeZip :: a zip archive of members holding the same small CSV
+func writeZip(t *testing.T, sa_members []string) string {
+	s_path := filepath.Join(t.TempDir(), "data.zip")
+	file, err := os.Create(s_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for _, s_member := range sa_members {
+		w, err := zw.Create(s_member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s_member[len(s_member)-1] != '/' {
+			io.WriteString(w, "id,name\n1,a\n")
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	return "file://" + s_path
+}
+
+func TestArchiveTables(t *testing.T) {
+	// temporary files are made in a directory of the test, to catch leaks
+	s_temp := t.TempDir()
+	t.Setenv("TMPDIR", s_temp)
+
+	for _, c := range archiveCases {
+		s_url := writeZip(t, c.members)
+		glob := c.glob
+		tables, err := archiveTables(context.Background(), tableConfig{Name: "t", DataURL: &s_url, ArchiveMembers: &glob})
+		if err != nil {
+			t.Errorf("%q: %v", c.members, err)
+			continue
+		}
+		var sa_tables []string
+		for _, table := range tables {
+			sa_tables = append(sa_tables, table.Name)
+		}
+		if len(sa_tables) != len(c.tables) {
+			t.Errorf("%q: tables %q, want %q", c.members, sa_tables, c.tables)
+			continue
+		}
+		for idx := range sa_tables {
+			if sa_tables[idx] != c.tables[idx] {
+				t.Errorf("%q: tables %q, want %q", c.members, sa_tables, c.tables)
+				break
+			}
+		}
+
+		// members are read from the shared download until it is removed,
+		// then from a download of their own
+		for _, b_removed := range []bool{false, true} {
+			if b_removed {
+				tables[0].download.remove()
+			}
+			for _, table := range tables {
+				data, err := openURL(context.Background(), table)
+				if err != nil {
+					t.Errorf("%s: %v", table.Name, err)
+					continue
+				}
+				b_data, err := io.ReadAll(data)
+				data.Close()
+				if err != nil || string(b_data) != "id,name\n1,a\n" {
+					t.Errorf("%s: read %q, %v", table.Name, b_data, err)
+				}
+			}
+		}
+		if entries, _ := os.ReadDir(s_temp); len(entries) > 0 {
+			t.Errorf("%q: temporary files left: %v", c.members, entries)
+		}
+	}
+}
+
+func TestArchiveMaxBytes(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	s_url := writeZip(t, []string{"a.csv"})
+
+	i_max_bytes := int64(50)
+	for _, c := range []struct {
+		policy string
+		fails  bool
+	}{
+		{"truncate", true},
+		{"error", true},
+		{"warn", false},
+	} {
+		policy := c.policy
+		tables, err := archiveTables(context.Background(), tableConfig{Name: "t", DataURL: &s_url, MaxBytes: &i_max_bytes, MaxBytesPolicy: &policy})
+		if (err != nil) != c.fails {
+			t.Errorf("%s: got %v, want failure %v", c.policy, err, c.fails)
+		}
+		if len(tables) > 0 {
+			tables[0].download.remove()
+		}
+	}
+}
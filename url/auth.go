@@ -0,0 +1,87 @@
+package url
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// resolveSecret :: return the value of a secret option. Values of the form
+// "env:NAME" are read from the environment and "file:PATH" from a file, any
+// other value is used as is.
+func resolveSecret(s_value string) (string, error) {
+	switch {
+	case strings.HasPrefix(s_value, "env:"):
+		s_name := strings.TrimPrefix(s_value, "env:")
+		s_secret, ok := os.LookupEnv(s_name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s_name)
+		}
+		return s_secret, nil
+	case strings.HasPrefix(s_value, "file:"):
+		s_path := strings.TrimPrefix(s_value, "file:")
+		buff, err := os.ReadFile(s_path)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %v", err)
+		}
+		return strings.TrimRight(string(buff), "\r\n"), nil
+	}
+	return s_value, nil
+}
+
+// optionalSecret :: resolveSecret for an option that may not be set
+func optionalSecret(s_value *string, s_option string) (string, error) {
+	if s_value == nil || *s_value == "" {
+		return "", nil
+	}
+	s_secret, err := resolveSecret(*s_value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", s_option, err)
+	}
+	return s_secret, nil
+}
+
+// applyAuth :: add the configured headers, credentials and API key to a
+// request for the table URL
+func applyAuth(req *http.Request, table tableConfig) error {
+
+	for s_name, s_value := range table.Headers {
+		s_header, err := resolveSecret(s_value)
+		if err != nil {
+			return fmt.Errorf("header %s: %v", s_name, err)
+		}
+		req.Header.Set(s_name, s_header)
+	}
+
+	if table.BasicAuthUsername != nil {
+		s_password, err := optionalSecret(table.BasicAuthPassword, "basic_auth_password")
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(*table.BasicAuthUsername, s_password)
+	}
+
+	s_token, err := optionalSecret(table.BearerToken, "bearer_token")
+	if err != nil {
+		return err
+	}
+	if s_token != "" {
+		req.Header.Set("Authorization", "Bearer "+s_token)
+	}
+
+	s_key, err := optionalSecret(table.APIKey, "api_key")
+	if err != nil {
+		return err
+	}
+	if s_key != "" {
+		if table.APIKeyParam == nil || *table.APIKeyParam == "" {
+			return fmt.Errorf("api_key is set without api_key_param")
+		}
+		query := req.URL.Query()
+		query.Set(*table.APIKeyParam, s_key)
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return nil
+}
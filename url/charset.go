@@ -0,0 +1,103 @@
+package url
+
+import (
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+)
+
+// i_default_min_confidence is the default encoding_min_confidence, below
+// which a detected encoding is not trusted and the data is read as UTF-8
+const i_default_min_confidence int64 = 20
+
+// detectEncoding :: the most likely encoding of unlabelled text from a
+// sample of it, with a confidence from 0 to 100. Valid UTF-8 is taken as
+// is, as is UTF-8 with a few stray invalid bytes, which are left to the
+// invalid_byte_policy. UTF-16 without a byte order mark is told by the zero
+// bytes of its ASCII characters, and anything else is left to statistical
+// detection of the single and multi byte charsets.
+func detectEncoding(sample []byte) (string, int) {
+
+	if len(sample) == 0 {
+		return "UTF-8", 100
+	}
+	if s_encoding, i_confidence := detectUTF16(sample); s_encoding != "" {
+		return s_encoding, i_confidence
+	}
+	if validUTF8Prefix(sample) {
+		return "UTF-8", 100
+	}
+	if i_confidence := mostlyUTF8(sample); i_confidence > 0 {
+		return "UTF-8", i_confidence
+	}
+	result, err := chardet.NewTextDetector().DetectBest(sample)
+	if err != nil {
+		return "", 0
+	}
+	return result.Charset, result.Confidence
+}
+
+// detectUTF16 :: UTF-16LE or UTF-16BE when most characters of the sample
+// have a zero high byte on the same side, as text made mostly of ASCII
+// does, with the share of such characters as the confidence
+func detectUTF16(sample []byte) (string, int) {
+	i_pairs := len(sample) / 2
+	if i_pairs < 8 {
+		return "", 0
+	}
+	i_even, i_odd := 0, 0
+	for idx := 0; idx+1 < len(sample); idx += 2 {
+		if sample[idx] == 0 {
+			i_even++
+		}
+		if sample[idx+1] == 0 {
+			i_odd++
+		}
+	}
+	switch {
+	case i_odd*10 >= i_pairs*4 && i_even*20 < i_pairs:
+		return "UTF-16LE", i_odd * 100 / i_pairs
+	case i_even*10 >= i_pairs*4 && i_odd*20 < i_pairs:
+		return "UTF-16BE", i_even * 100 / i_pairs
+	}
+	return "", 0
+}
+
+// validUTF8Prefix :: whether a sample is valid UTF-8, allowing a character
+// cut short at its end
+func validUTF8Prefix(sample []byte) bool {
+	for len(sample) > 0 {
+		r, i_size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && i_size <= 1 {
+			return !utf8.FullRune(sample)
+		}
+		sample = sample[i_size:]
+	}
+	return true
+}
+
+// mostlyUTF8 :: a confidence that a sample holding invalid bytes is UTF-8
+// all the same, or 0. That is when its multi byte characters are valid UTF-8
+// at least as often as not, which text in other charsets rarely manages.
+func mostlyUTF8(sample []byte) int {
+	i_multibyte, i_invalid := 0, 0
+	for len(sample) > 0 {
+		r, i_size := utf8.DecodeRune(sample)
+		switch {
+		case r == utf8.RuneError && i_size <= 1:
+			if !utf8.FullRune(sample) {
+				// a character cut short at the end of the sample
+				sample = nil
+				continue
+			}
+			i_invalid++
+		case i_size > 1:
+			i_multibyte++
+		}
+		sample = sample[i_size:]
+	}
+	if i_multibyte == 0 || i_invalid > i_multibyte {
+		return 0
+	}
+	return i_multibyte * 100 / (i_multibyte + i_invalid)
+}
@@ -0,0 +1,322 @@
+//go:build !cgo
+
+package url
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/transform"
+)
+
+// pureCharset is how an encoding name is read and written
+type pureCharset struct {
+	encoding encoding.Encoding
+	bom      bool // a leading byte order mark gives the byte order
+	stateful bool // characters depend on a byte order mark before them
+}
+
+// pureCharsets are the encodings whose iconv behaviour is not that of
+// their IANA or WHATWG entries. UTF-16 and UTF-32 without a byte order
+// mark are read and written in the order of the host, little endian on
+// the platforms we build for. The WHATWG reads ASCII as windows-1252 and
+// TIS-620 as windows-874.
+var pureCharsets = map[string]pureCharset{
+	"UTF-8":       {encoding: utf8Charset{}},
+	"UTF8":        {encoding: utf8Charset{}},
+	"UTF-16":      {encoding: utf16Charset{unicode.LittleEndian, unicode.UseBOM}, bom: true, stateful: true},
+	"UTF-16BE":    {encoding: utf16Charset{unicode.BigEndian, unicode.IgnoreBOM}},
+	"UTF-16LE":    {encoding: utf16Charset{unicode.LittleEndian, unicode.IgnoreBOM}},
+	"UTF-32":      {encoding: utf32.UTF32(utf32.LittleEndian, utf32.UseBOM), bom: true, stateful: true},
+	"UTF-32BE":    {encoding: utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM)},
+	"UTF-32LE":    {encoding: utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM)},
+	"ASCII":       {encoding: newByteCharset(charmap.Windows1252, asciiOnly)},
+	"US-ASCII":    {encoding: newByteCharset(charmap.Windows1252, asciiOnly)},
+	"TIS-620":     {encoding: newByteCharset(charmap.Windows874, tis620Gaps)},
+	"TIS620":      {encoding: newByteCharset(charmap.Windows874, tis620Gaps)},
+	"MACCYRILLIC": {encoding: newByteCharset(charmap.MacintoshCyrillic, byteOverrides[charmap.MacintoshCyrillic])},
+}
+
+// byteOverrides are the bytes of single byte charsets that iconv reads
+// differently from the x/text table, utf8.RuneError where it has none
+var byteOverrides = map[*charmap.Charmap]map[byte]rune{
+	charmap.KOI8U:             {0xae: 0x255d, 0xbe: 0x256c},
+	charmap.Macintosh:         {0xc6: 0x0394, 0xf0: 0xe01e},
+	charmap.MacintoshCyrillic: {0xff: 0x00a4},
+}
+
+// asciiOnly leaves only the ASCII half of a single byte charset
+var asciiOnly = func() map[byte]rune {
+	overrides := map[byte]rune{}
+	for b := 0x80; b < 0x100; b++ {
+		overrides[byte(b)] = utf8.RuneError
+	}
+	return overrides
+}()
+
+// tis620Gaps are the windows-874 additions that TIS-620 does not have
+var tis620Gaps = map[byte]rune{
+	0x80: utf8.RuneError, 0x85: utf8.RuneError, 0x91: utf8.RuneError, 0x92: utf8.RuneError,
+	0x93: utf8.RuneError, 0x94: utf8.RuneError, 0x95: utf8.RuneError, 0x96: utf8.RuneError,
+	0x97: utf8.RuneError, 0xa0: utf8.RuneError,
+}
+
+// lookupCharset :: the charset of an encoding name, as iconv spells them or
+// as registered with IANA or the WHATWG. Only the charsets read exactly as
+// iconv reads them are known: the multibyte charsets of Asia, whose tables
+// differ from those of glibc in thousands of characters, and windows-1255
+// and windows-1258, whose combining marks iconv composes with the letter
+// before them, are not.
+func lookupCharset(name string) (pureCharset, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if charset, ok := pureCharsets[name]; ok {
+		return charset, true
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		if enc, err = htmlindex.Get(name); err != nil {
+			return pureCharset{}, false
+		}
+	}
+	table, ok := enc.(*charmap.Charmap)
+	if !ok || table == charmap.Windows1255 || table == charmap.Windows1258 {
+		return pureCharset{}, false
+	}
+	return pureCharset{encoding: newByteCharset(table, byteOverrides[table])}, true
+}
+
+// byteCharset is a single byte charset with the bytes iconv has for it,
+// which fails on bytes that are not characters rather than replacing them
+type byteCharset struct {
+	decode [256]rune // utf8.RuneError for bytes that are not characters
+	encode map[rune]byte
+}
+
+// newByteCharset :: the charset of an x/text table, with overrides. The C1
+// control bytes that ISO 8859 leaves out are read as the C1 controls.
+func newByteCharset(table *charmap.Charmap, overrides map[byte]rune) *byteCharset {
+	charset := &byteCharset{encode: map[rune]byte{}}
+	iso8859 := strings.HasPrefix(table.String(), "ISO 8859-")
+	for i := 0; i < 256; i++ {
+		r := table.DecodeByte(byte(i))
+		if override, ok := overrides[byte(i)]; ok {
+			r = override
+		} else if r == utf8.RuneError && iso8859 && i >= 0x80 && i < 0xa0 {
+			r = rune(i)
+		}
+		charset.decode[i] = r
+		if _, ok := charset.encode[r]; !ok && r != utf8.RuneError {
+			charset.encode[r] = byte(i)
+		}
+	}
+	return charset
+}
+
+func (this *byteCharset) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: byteDecoder{this}}
+}
+
+func (this *byteCharset) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: byteEncoder{this}}
+}
+
+type byteDecoder struct{ charset *byteCharset }
+
+func (byteDecoder) Reset() {}
+
+func (this byteDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for ; nSrc < len(src); nSrc++ {
+		r := this.charset.decode[src[nSrc]]
+		if r == utf8.RuneError {
+			return nDst, nSrc, errIllegalSequence
+		}
+		if nDst+utf8.RuneLen(r) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+	}
+	return nDst, nSrc, nil
+}
+
+type byteEncoder struct{ charset *byteCharset }
+
+func (byteEncoder) Reset() {}
+
+func (this byteEncoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		r, size := utf8.DecodeRune(src[nSrc:])
+		if r == utf8.RuneError && size <= 1 {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			return nDst, nSrc, errIllegalSequence
+		}
+		b, ok := this.charset.encode[r]
+		if !ok {
+			return nDst, nSrc, errIllegalSequence
+		}
+		if nDst >= len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		dst[nDst] = b
+		nDst++
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// utf8Charset is UTF-8 checked the way iconv checks it: a sequence is
+// incomplete for as long as continuation bytes follow its lead byte, even
+// those of the old five and six byte forms, and invalid as soon as one
+// does not
+type utf8Charset struct{}
+
+func (utf8Charset) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: utf8Decoder{}}
+}
+
+func (utf8Charset) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: utf8Decoder{}}
+}
+
+type utf8Decoder struct{}
+
+func (utf8Decoder) Reset() {}
+
+func (utf8Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		size := 1
+		if src[nSrc] >= utf8.RuneSelf {
+			var r rune
+			r, size = utf8.DecodeRune(src[nSrc:])
+			if r == utf8.RuneError && size <= 1 {
+				if !atEOF && incompleteUTF8(src[nSrc:]) {
+					return nDst, nSrc, transform.ErrShortSrc
+				}
+				return nDst, nSrc, errIllegalSequence
+			}
+		}
+		if nDst+size > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], src[nSrc:nSrc+size])
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// incompleteUTF8 :: whether input is a lead byte followed by fewer
+// continuation bytes than it calls for
+func incompleteUTF8(input []byte) bool {
+	size := 0
+	switch lead := input[0]; {
+	case lead >= 0xc2 && lead <= 0xdf:
+		size = 2
+	case lead >= 0xe0 && lead <= 0xef:
+		size = 3
+	case lead >= 0xf0 && lead <= 0xf7:
+		size = 4
+	case lead >= 0xf8 && lead <= 0xfb:
+		size = 5
+	case lead >= 0xfc && lead <= 0xfd:
+		size = 6
+	}
+	if len(input) >= size {
+		return false
+	}
+	for _, b := range input[1:] {
+		if b&0xc0 != 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// utf16Charset is UTF-16 checked the way iconv checks it, which rejects a
+// low surrogate as soon as it is read, where x/text waits for the next
+type utf16Charset struct {
+	endianness unicode.Endianness
+	bomPolicy  unicode.BOMPolicy
+}
+
+func (this utf16Charset) NewDecoder() *encoding.Decoder {
+	decoder := &utf16Decoder{order: binary.LittleEndian, bom: this.bomPolicy != unicode.IgnoreBOM}
+	decoder.Reset()
+	if this.endianness == unicode.BigEndian {
+		decoder.order = binary.BigEndian
+	}
+	return &encoding.Decoder{Transformer: decoder}
+}
+
+func (this utf16Charset) NewEncoder() *encoding.Encoder {
+	return unicode.UTF16(this.endianness, this.bomPolicy).NewEncoder()
+}
+
+type utf16Decoder struct {
+	order    binary.ByteOrder
+	bom      bool // a byte order mark is looked for after a reset
+	checkBOM bool // a byte order mark may still come first
+}
+
+// Reset looks for a byte order mark again, keeping the order of the last
+// one until there is a new one, as iconv does
+func (this *utf16Decoder) Reset() {
+	this.checkBOM = this.bom
+}
+
+func (this *utf16Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc+1 < len(src) {
+		if this.checkBOM {
+			this.checkBOM = false
+			switch {
+			case src[nSrc] == 0xff && src[nSrc+1] == 0xfe:
+				this.order = binary.LittleEndian
+				nSrc += 2
+				continue
+			case src[nSrc] == 0xfe && src[nSrc+1] == 0xff:
+				this.order = binary.BigEndian
+				nSrc += 2
+				continue
+			}
+		}
+
+		unit := this.order.Uint16(src[nSrc:])
+		r, size := rune(unit), 2
+		switch {
+		case unit >= 0xdc00 && unit < 0xe000:
+			return nDst, nSrc, errIllegalSequence
+		case unit >= 0xd800 && unit < 0xdc00:
+			if len(src)-nSrc < 4 {
+				if atEOF {
+					return nDst, nSrc, errIllegalSequence
+				}
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			low := this.order.Uint16(src[nSrc+2:])
+			if low < 0xdc00 || low >= 0xe000 {
+				return nDst, nSrc, errIllegalSequence
+			}
+			r, size = 0x10000+(rune(unit)-0xd800)<<10+rune(low)-0xdc00, 4
+		}
+		if nDst+utf8.RuneLen(r) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += utf8.EncodeRune(dst[nDst:], r)
+		nSrc += size
+	}
+
+	if nSrc < len(src) {
+		if atEOF {
+			return nDst, nSrc, errIllegalSequence
+		}
+		return nDst, nSrc, transform.ErrShortSrc
+	}
+	return nDst, nSrc, nil
+}
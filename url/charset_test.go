@@ -0,0 +1,30 @@
+package url
+
+import "testing"
+
+var detectCases = []struct {
+	name     string
+	sample   string
+	encoding string
+}{
+	{"empty", "", "UTF-8"},
+	{"ascii", "id,name\n1,a\n", "UTF-8"},
+	{"utf-8", "id,name\n1,café\n2,naïve\n", "UTF-8"},
+	{"utf-8 cut at the end", "id,name\n1,caf\xc3", "UTF-8"},
+	{"utf-8 with a stray byte", "id,name\n1,café\n2,naïve\xff\n", "UTF-8"},
+	{"latin-1", "id,name\n1,caf\xe9\n2,na\xefve\n3,gar\xe7on\n4,\xe0 la carte\n", "ISO-8859-1"},
+	{"utf-16le", "i\x00d\x00,\x00n\x00a\x00m\x00e\x00\n\x001\x00,\x00a\x00\n\x00", "UTF-16LE"},
+	{"utf-16be", "\x00i\x00d\x00,\x00n\x00a\x00m\x00e\x00\n\x001\x00,\x00a\x00\n", "UTF-16BE"},
+}
+
+func TestDetectEncoding(t *testing.T) {
+	for _, c := range detectCases {
+		s_encoding, i_confidence := detectEncoding([]byte(c.sample))
+		if s_encoding != c.encoding {
+			t.Errorf("%s: detected %s with confidence %d, want %s", c.name, s_encoding, i_confidence, c.encoding)
+		}
+		if s_encoding == "UTF-8" && int64(i_confidence) < i_default_min_confidence {
+			t.Errorf("%s: UTF-8 detected with confidence %d, below the default minimum", c.name, i_confidence)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Values of the compression option, other than auto and none
+const (
+	compressionGzip  = "gzip"
+	compressionBzip2 = "bzip2"
+	compressionXz    = "xz"
+	compressionZstd  = "zstd"
+)
+
+// compressionMagic are the leading bytes of each compressed format. bzip2
+// data is further checked by hasBzip2Magic, as text may start with "BZh".
+var compressionMagic = map[string][]byte{
+	compressionGzip:  {0x1f, 0x8b},
+	compressionBzip2: []byte("BZh"),
+	compressionXz:    {0xfd, '7', 'z', 'X', 'Z', 0x00},
+	compressionZstd:  {0x28, 0xb5, 0x2f, 0xfd},
+}
+
+// bzip2 blocks start with the digits of pi, and the end of the stream, which
+// follows the header directly in empty data, with the square root of pi
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// hasCompressionMagic :: whether data starts with the magic bytes of a format
+func hasCompressionMagic(magic []byte, s_format string) bool {
+	if !bytes.HasPrefix(magic, compressionMagic[s_format]) {
+		return false
+	}
+	if s_format != compressionBzip2 {
+		return true
+	}
+	return hasBzip2Magic(magic)
+}
+
+// hasBzip2Magic :: whether data starts with a bzip2 header, "BZh" and a block
+// size from 1 to 9, followed by a block or the end of the stream
+func hasBzip2Magic(magic []byte) bool {
+	if len(magic) < 10 || magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+	return bytes.Equal(magic[4:10], bzip2BlockMagic) || bytes.Equal(magic[4:10], bzip2EndMagic)
+}
+
+// compressionExtensions maps file extensions to compressed formats
+var compressionExtensions = map[string]string{
+	".gz":   compressionGzip,
+	".tgz":  compressionGzip,
+	".bz2":  compressionBzip2,
+	".tbz2": compressionBzip2,
+	".xz":   compressionXz,
+	".txz":  compressionXz,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+}
+
+// readCloser closes every layer wrapped around a response body
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if cerr := closer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// decompress :: wrap fetched data in a decompressor. With the compression
+// option unset or "auto" the format is taken from the Content-Encoding
+// header or the URL extension and confirmed by the magic bytes, which also
+// catch compressed data that is not labelled at all. "none" reads the data
+// as is and a format name forces that format.
+func decompress(data *fetchedData, s_option string) (*fetchedData, error) {
+
+	s_option = strings.ToLower(s_option)
+	if s_option == "none" {
+		return data, nil
+	}
+
+	br := bufio.NewReader(data)
+	magic, _ := br.Peek(10)
+
+	var s_format string
+	switch s_option {
+	case "", "auto":
+		s_hint := strings.ToLower(strings.TrimSpace(data.ContentEncoding))
+		if s_hint == "x-gzip" {
+			s_hint = compressionGzip
+		}
+		if _, ok := compressionMagic[s_hint]; !ok {
+			s_hint = compressionExtensions[strings.ToLower(path.Ext(data.Path))]
+		}
+		if s_hint != "" && hasCompressionMagic(magic, s_hint) {
+			s_format = s_hint
+		} else {
+			// a labelled body may already have been decoded by the transport,
+			// so fall back on the magic bytes alone
+			for s_name := range compressionMagic {
+				if hasCompressionMagic(magic, s_name) {
+					s_format = s_name
+				}
+			}
+		}
+	case compressionGzip, compressionBzip2, compressionXz, compressionZstd:
+		s_format = s_option
+	default:
+		return nil, fmt.Errorf("compression must be one of auto, none, gzip, bzip2, xz or zstd, got %q", s_option)
+	}
+
+	decompressed := &readCloser{Reader: br, closers: []io.Closer{data.ReadCloser}}
+	switch s_format {
+	case compressionGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading gzip data from %s: %v", data.URL, err)
+		}
+		decompressed.Reader = gr
+		decompressed.closers = append([]io.Closer{gr}, decompressed.closers...)
+	case compressionBzip2:
+		decompressed.Reader = bzip2.NewReader(br)
+	case compressionXz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading xz data from %s: %v", data.URL, err)
+		}
+		decompressed.Reader = xr
+	case compressionZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd data from %s: %v", data.URL, err)
+		}
+		decompressed.Reader = zr
+		decompressed.closers = append([]io.Closer{zstdCloser{zr}}, decompressed.closers...)
+	}
+
+	result := *data
+	result.ReadCloser = decompressed
+	if s_format != "" {
+		// drop the compression extension so the rest describes the content
+		s_ext := path.Ext(data.Path)
+		switch strings.ToLower(s_ext) {
+		case ".tgz", ".tbz2", ".txz":
+			result.Path = strings.TrimSuffix(data.Path, s_ext) + ".tar"
+		default:
+			if _, ok := compressionExtensions[strings.ToLower(s_ext)]; ok {
+				result.Path = strings.TrimSuffix(data.Path, s_ext)
+			}
+		}
+		result.ContentEncoding = ""
+	}
+	return &result, nil
+}
+
+// zstdCloser adapts zstd.Decoder, whose Close returns nothing
+type zstdCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (c zstdCloser) Close() error {
+	c.decoder.Close()
+	return nil
+}
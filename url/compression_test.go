@@ -0,0 +1,82 @@
+package url
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2 of "id\n1\n", and of empty data, which has no block
+var (
+	bzip2Data  = "BZh91AY&SY2\xbe;\xb1\x00\x00\x02I\x00\x00\x10 \x00\x04  \x000\xcd4\x19\x90\xae8\xbb\x92)\xc2\x84\x81\x95\xf1\xdd\x88"
+	bzip2Empty = "BZh9\x17rE8P\x90\x00\x00\x00\x00"
+)
+
+// compressTest :: data compressed in a format by its writer
+func compressTest(t *testing.T, s_format string, s_data string) string {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch s_format {
+	case compressionGzip:
+		w = gzip.NewWriter(&buf)
+	case compressionXz:
+		w, err = xz.NewWriter(&buf)
+	case compressionZstd:
+		w, err = zstd.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, s_data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDecompress(t *testing.T) {
+	cases := []struct {
+		name     string
+		option   string
+		path     string
+		encoding string
+		data     string
+		output   string
+	}{
+		{"plain", "", "data.csv", "", "id\n1\n", "id\n1\n"},
+		{"gzip sniffed", "", "data", "", compressTest(t, compressionGzip, "id\n1\n"), "id\n1\n"},
+		{"gzip by header", "auto", "data", "x-gzip", compressTest(t, compressionGzip, "id\n1\n"), "id\n1\n"},
+		{"gzip decoded by the transport", "", "data.csv.gz", "gzip", "id\n1\n", "id\n1\n"},
+		{"xz by extension", "", "data.csv.xz", "", compressTest(t, compressionXz, "id\n1\n"), "id\n1\n"},
+		{"zstd sniffed", "", "data", "", compressTest(t, compressionZstd, "id\n1\n"), "id\n1\n"},
+		{"bzip2 sniffed", "", "data", "", bzip2Data, "id\n1\n"},
+		{"empty bzip2 sniffed", "", "data", "", bzip2Empty, ""},
+		{"bzip2 forced", "bzip2", "data.csv", "", bzip2Data, "id\n1\n"},
+		{"text starting with BZh", "", "data", "", "BZh name\nBZh9 x\n", "BZh name\nBZh9 x\n"},
+		{"text starting with a bzip2 header", "", "data", "", "BZh91AY&SX\n", "BZh91AY&SX\n"},
+		{"text labelled bzip2", "", "data.bz2", "", "BZh9 x\n", "BZh9 x\n"},
+		{"none", "none", "data.gz", "", "\x1f\x8b", "\x1f\x8b"},
+	}
+	for _, c := range cases {
+		data := &fetchedData{ReadCloser: io.NopCloser(bytes.NewReader([]byte(c.data))), Path: c.path, ContentEncoding: c.encoding}
+		decompressed, err := decompress(data, c.option)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		output, err := io.ReadAll(decompressed)
+		decompressed.Close()
+		if string(output) != c.output || err != nil {
+			t.Errorf("%s: read %q, %v; want %q", c.name, output, err, c.output)
+		}
+	}
+
+	if _, err := decompress(&fetchedData{ReadCloser: io.NopCloser(bytes.NewReader(nil))}, "lz4"); err == nil {
+		t.Errorf("unknown compression option accepted")
+	}
+}
@@ -1,14 +1,132 @@
 package url
 
 import (
+	"reflect"
+
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 )
 
 type urlConfig struct {
-	DataURL *string `hcl:"dataURL"`
-	Separator *string `hcl:"separator"`
-	Comment *string `hcl:"comment"`
-	Header *string `hcl:"header"`
+	DataURL                *string           `hcl:"dataURL"`
+	Separator              *string           `hcl:"separator"`
+	Comment                *string           `hcl:"comment"`
+	Header                 *string           `hcl:"header"`
+	MaxBytes               *int64            `hcl:"max_bytes"`
+	MaxBytesPolicy         *string           `hcl:"max_bytes_policy"`
+	Headers                map[string]string `hcl:"headers,optional"`
+	BasicAuthUsername      *string           `hcl:"basic_auth_username"`
+	BasicAuthPassword      *string           `hcl:"basic_auth_password"`
+	BearerToken            *string           `hcl:"bearer_token"`
+	APIKeyParam            *string           `hcl:"api_key_param"`
+	APIKey                 *string           `hcl:"api_key"`
+	OAuth2TokenURL         *string           `hcl:"oauth2_token_url"`
+	OAuth2ClientID         *string           `hcl:"oauth2_client_id"`
+	OAuth2ClientSecret     *string           `hcl:"oauth2_client_secret"`
+	OAuth2RefreshToken     *string           `hcl:"oauth2_refresh_token"`
+	OAuth2Scopes           []string          `hcl:"oauth2_scopes,optional"`
+	S3Endpoint             *string           `hcl:"s3_endpoint"`
+	S3Region               *string           `hcl:"s3_region"`
+	S3AccessKeyID          *string           `hcl:"s3_access_key_id"`
+	S3SecretAccessKey      *string           `hcl:"s3_secret_access_key"`
+	S3SessionToken         *string           `hcl:"s3_session_token"`
+	S3PathStyle            *bool             `hcl:"s3_path_style"`
+	TLSClientCert          *string           `hcl:"tls_client_cert"`
+	TLSClientKey           *string           `hcl:"tls_client_key"`
+	TLSCABundles           []string          `hcl:"tls_ca_bundles,optional"`
+	TLSInsecureSkipVerify  *bool             `hcl:"tls_insecure_skip_verify"`
+	ProxyURL               *string           `hcl:"proxy_url"`
+	RedirectPolicy         *string           `hcl:"redirect_policy"`
+	MaxRedirects           *int64            `hcl:"max_redirects"`
+	ConnectTimeout         *string           `hcl:"connect_timeout"`
+	ReadTimeout            *string           `hcl:"read_timeout"`
+	Timeout                *string           `hcl:"timeout"`
+	MaxRetries             *int64            `hcl:"max_retries"`
+	RetryBackoff           *string           `hcl:"retry_backoff"`
+	RetryMaxBackoff        *string           `hcl:"retry_max_backoff"`
+	Compression            *string           `hcl:"compression"`
+	Encoding               *string           `hcl:"encoding"`
+	EncodingMinConfidence  *int64            `hcl:"encoding_min_confidence"`
+	InvalidBytePolicy      *string           `hcl:"invalid_byte_policy"`
+	InvalidByteReplacement *string           `hcl:"invalid_byte_replacement"`
+	Archive                *string           `hcl:"archive"`
+	ArchiveMembers         *string           `hcl:"archive_members"`
+	Format                 *string           `hcl:"format"`
+	JSONPath               *string           `hcl:"json_path"`
+	RecordPath             *string           `hcl:"record_path"`
+	HTMLSelector           *string           `hcl:"html_selector"`
+	HTMLTableIndex         *int64            `hcl:"html_table_index"`
+	FixedWidthColumns      [][]int64         `hcl:"fixed_width_columns,optional"`
+	FixedWidthRuler        *string           `hcl:"fixed_width_ruler"`
+	SkipRows               *int64            `hcl:"skip_rows"`
+	HeaderRow              *int64            `hcl:"header_row"`
+	Tables                 []tableConfig     `hcl:"tables,block"`
+}
+
+// tableConfig describes a single URL-backed table. Options left unset fall
+// back to the connection level value of the same name, so every exported
+// field apart from Name must be a pointer, map or slice that is nil when not
+// set.
+type tableConfig struct {
+	Name                   string            `hcl:"name,label"`
+	DataURL                *string           `hcl:"dataURL"`
+	Separator              *string           `hcl:"separator"`
+	Comment                *string           `hcl:"comment"`
+	Header                 *string           `hcl:"header"`
+	MaxBytes               *int64            `hcl:"max_bytes"`
+	MaxBytesPolicy         *string           `hcl:"max_bytes_policy"`
+	Headers                map[string]string `hcl:"headers,optional"`
+	BasicAuthUsername      *string           `hcl:"basic_auth_username"`
+	BasicAuthPassword      *string           `hcl:"basic_auth_password"`
+	BearerToken            *string           `hcl:"bearer_token"`
+	APIKeyParam            *string           `hcl:"api_key_param"`
+	APIKey                 *string           `hcl:"api_key"`
+	OAuth2TokenURL         *string           `hcl:"oauth2_token_url"`
+	OAuth2ClientID         *string           `hcl:"oauth2_client_id"`
+	OAuth2ClientSecret     *string           `hcl:"oauth2_client_secret"`
+	OAuth2RefreshToken     *string           `hcl:"oauth2_refresh_token"`
+	OAuth2Scopes           []string          `hcl:"oauth2_scopes,optional"`
+	S3Endpoint             *string           `hcl:"s3_endpoint"`
+	S3Region               *string           `hcl:"s3_region"`
+	S3AccessKeyID          *string           `hcl:"s3_access_key_id"`
+	S3SecretAccessKey      *string           `hcl:"s3_secret_access_key"`
+	S3SessionToken         *string           `hcl:"s3_session_token"`
+	S3PathStyle            *bool             `hcl:"s3_path_style"`
+	TLSClientCert          *string           `hcl:"tls_client_cert"`
+	TLSClientKey           *string           `hcl:"tls_client_key"`
+	TLSCABundles           []string          `hcl:"tls_ca_bundles,optional"`
+	TLSInsecureSkipVerify  *bool             `hcl:"tls_insecure_skip_verify"`
+	ProxyURL               *string           `hcl:"proxy_url"`
+	RedirectPolicy         *string           `hcl:"redirect_policy"`
+	MaxRedirects           *int64            `hcl:"max_redirects"`
+	ConnectTimeout         *string           `hcl:"connect_timeout"`
+	ReadTimeout            *string           `hcl:"read_timeout"`
+	Timeout                *string           `hcl:"timeout"`
+	MaxRetries             *int64            `hcl:"max_retries"`
+	RetryBackoff           *string           `hcl:"retry_backoff"`
+	RetryMaxBackoff        *string           `hcl:"retry_max_backoff"`
+	Compression            *string           `hcl:"compression"`
+	Encoding               *string           `hcl:"encoding"`
+	EncodingMinConfidence  *int64            `hcl:"encoding_min_confidence"`
+	InvalidBytePolicy      *string           `hcl:"invalid_byte_policy"`
+	InvalidByteReplacement *string           `hcl:"invalid_byte_replacement"`
+	Archive                *string           `hcl:"archive"`
+	ArchiveMembers         *string           `hcl:"archive_members"`
+	Format                 *string           `hcl:"format"`
+	JSONPath               *string           `hcl:"json_path"`
+	RecordPath             *string           `hcl:"record_path"`
+	HTMLSelector           *string           `hcl:"html_selector"`
+	HTMLTableIndex         *int64            `hcl:"html_table_index"`
+	FixedWidthColumns      [][]int64         `hcl:"fixed_width_columns,optional"`
+	FixedWidthRuler        *string           `hcl:"fixed_width_ruler"`
+	SkipRows               *int64            `hcl:"skip_rows"`
+	HeaderRow              *int64            `hcl:"header_row"`
+
+	// set for the tables expanded from an archive
+	member   string
+	archive  string
+	download *zipDownload // zip archives, while the schema is built
+	// set for the tables expanded from the sheets of a spreadsheet
+	sheet string
 }
 
 func ConfigInstance() interface{} {
@@ -24,3 +142,40 @@ func GetConfig(connection *plugin.Connection) urlConfig {
 	config, _ := connection.Config.(urlConfig)
 	return config
 }
+
+// tableConfigs :: list the tables defined by the connection, with connection
+// level options applied to any table that does not set its own. A top level
+// dataURL is still served as the "http" table.
+func (config urlConfig) tableConfigs() []tableConfig {
+
+	var tables []tableConfig
+	if config.DataURL != nil {
+		tables = append(tables, tableConfig{Name: "http", DataURL: config.DataURL})
+	}
+	tables = append(tables, config.Tables...)
+
+	defaults := reflect.ValueOf(config)
+	for idx := range tables {
+		table := reflect.ValueOf(&tables[idx]).Elem()
+		for i := 0; i < table.NumField(); i++ {
+			field := table.Field(i)
+			s_name := table.Type().Field(i).Name
+			if !table.Type().Field(i).IsExported() || s_name == "Name" || s_name == "DataURL" {
+				continue
+			}
+			// options are pointers or slices, nil when not set
+			switch field.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map:
+				if !field.IsNil() {
+					continue
+				}
+			default:
+				continue
+			}
+			if fallback := defaults.FieldByName(s_name); fallback.IsValid() && fallback.Type() == field.Type() {
+				field.Set(fallback)
+			}
+		}
+	}
+	return tables
+}
@@ -1,3 +1,5 @@
+//go:build cgo
+
 package url
 
 /*
@@ -42,13 +44,15 @@ size_t call_iconv(iconv_t ctx, char *in, size_t *size_in, char *out, size_t *siz
 
 */
 import "C"
-import "io"
 import "syscall"
 import "unsafe"
 
 type Converter struct {
-	context C.iconv_t
-	open    bool
+	context     C.iconv_t
+	open        bool
+	substitute  func(invalid byte) []byte
+	substituted int64
+	unitSize    int // of fromEncoding, the bytes skipped for invalid input
 }
 
 // Initialize a new Converter. If fromEncoding or toEncoding are not supported by
@@ -72,6 +76,7 @@ func NewConverter(fromEncoding string, toEncoding string) (converter *Converter,
 	if err == nil {
 		// no error, mark the context as open
 		converter.open = true
+		converter.unitSize = codeUnitSize(fromEncoding)
 	}
 
 	return
@@ -86,23 +91,14 @@ func (this *Converter) destroy() {
 func (this *Converter) Close() (err error) {
 	if this.open {
 		_, err = C.iconv_close(this.context)
+		this.open = false
 	}
 
 	return
 }
 
-// Convert bytes from an input byte slice into a give output byte slice
-//
-// As many bytes that can converted and fit into the size of output will be
-// processed and the number of bytes read for input as well as the number of
-// bytes written to output will be returned. If not all converted bytes can fit
-// into output and E2BIG error will also be returned. If input contains an invalid
-// sequence of bytes for the Converter's fromEncoding an EILSEQ error will be returned
-//
-// For shift based output encodings, any end shift byte sequences can be generated by
-// passing a 0 length byte slice as input. Also passing a 0 length byte slice for output
-// will simply reset the iconv descriptor shift state without writing any bytes.
-func (this *Converter) Convert(input []byte, output []byte) (bytesRead int, bytesWritten int, err error) {
+// convert is Convert with iconv alone, failing on any invalid input
+func (this *Converter) convert(input []byte, output []byte) (bytesRead int, bytesWritten int, err error) {
 	// make sure we are still open
 	if this.open {
 		inputLeft := C.size_t(len(input))
@@ -138,279 +134,3 @@ func (this *Converter) Convert(input []byte, output []byte) (bytesRead int, byte
 
 	return bytesRead, bytesWritten, err
 }
-
-// Convert an input string
-//
-// EILSEQ error may be returned if input contains invalid bytes for the
-// Converter's fromEncoding.
-func (this *Converter) ConvertString(input string) (output string, err error) {
-	// make sure we are still open
-	if this.open {
-		// construct the buffers
-		inputBuffer := []byte(input)
-		outputBuffer := make([]byte, len(inputBuffer)*2) // we use a larger buffer to help avoid resizing later
-
-		// call Convert until all input bytes are read or an error occurs
-		var bytesRead, totalBytesRead, bytesWritten, totalBytesWritten int
-
-		for totalBytesRead < len(inputBuffer) && err == nil {
-			// use the totals to create buffer slices
-			bytesRead, bytesWritten, err = this.Convert(inputBuffer[totalBytesRead:], outputBuffer[totalBytesWritten:])
-
-			totalBytesRead += bytesRead
-			totalBytesWritten += bytesWritten
-
-			// check for the E2BIG error specifically, we can add to the output
-			// buffer to correct for it and then continue
-			if err == syscall.E2BIG {
-				// increase the size of the output buffer by another input length
-				// first, create a new buffer
-				tempBuffer := make([]byte, len(outputBuffer)+len(inputBuffer))
-
-				// copy the existing data
-				copy(tempBuffer, outputBuffer)
-
-				// switch the buffers
-				outputBuffer = tempBuffer
-
-				// forget the error
-				err = nil
-			}
-		}
-
-		if err == nil {
-			// perform a final shift state reset
-			_, bytesWritten, err = this.Convert([]byte{}, outputBuffer[totalBytesWritten:])
-
-			// update total count
-			totalBytesWritten += bytesWritten
-		}
-
-		// construct the final output string
-		output = string(outputBuffer[:totalBytesWritten])
-	} else {
-		err = syscall.EBADF
-	}
-
-	return output, err
-}
-
-
-
-
-// All in one Convert method, rather than requiring the construction of an iconv.Converter
-func Convert(input []byte, output []byte, fromEncoding string, toEncoding string) (bytesRead int, bytesWritten int, err error) {
-	// create a temporary converter
-	converter, err := NewConverter(fromEncoding, toEncoding)
-
-	if err == nil {
-		// call converter's Convert
-		bytesRead, bytesWritten, err = converter.Convert(input, output)
-
-		if err == nil {
-			var shiftBytesWritten int
-
-			// call Convert with a nil input to generate any end shift sequences
-			_, shiftBytesWritten, err = converter.Convert(nil, output[bytesWritten:])
-
-			// add shift bytes to total bytes
-			bytesWritten += shiftBytesWritten
-		}
-
-		// close the converter
-		converter.Close()
-	}
-
-	return
-}
-
-// All in one ConvertString method, rather than requiring the construction of an iconv.Converter
-func ConvertString(input string, fromEncoding string, toEncoding string) (output string, err error) {
-	// create a temporary converter
-	converter, err := NewConverter(fromEncoding, toEncoding)
-
-	if err == nil {
-		// convert the string
-		output, err = converter.ConvertString(input)
-
-		// close the converter
-		converter.Close()
-	}
-
-	return
-}
-
-
-type Reader struct {
-	source            io.Reader
-	converter         *Converter
-	buffer            []byte
-	readPos, writePos int
-	err               error
-}
-
-func NewReader(source io.Reader, fromEncoding string, toEncoding string) (*Reader, error) {
-	// create a converter
-	converter, err := NewConverter(fromEncoding, toEncoding)
-
-	if err == nil {
-		return NewReaderFromConverter(source, converter), err
-	}
-
-	// return the error
-	return nil, err
-}
-
-func NewReaderFromConverter(source io.Reader, converter *Converter) (reader *Reader) {
-	reader = new(Reader)
-
-	// copy elements
-	reader.source = source
-	reader.converter = converter
-
-	// create 8K buffers
-	reader.buffer = make([]byte, 8*1024)
-
-	return reader
-}
-
-func (this *Reader) fillBuffer() {
-	// slide existing data to beginning
-	if this.readPos > 0 {
-		// copy current bytes - is this guaranteed safe?
-		copy(this.buffer, this.buffer[this.readPos:this.writePos])
-
-		// adjust positions
-		this.writePos -= this.readPos
-		this.readPos = 0
-	}
-
-	// read new data into buffer at write position
-	bytesRead, err := this.source.Read(this.buffer[this.writePos:])
-
-	// adjust write position
-	this.writePos += bytesRead
-
-	// track any reader error / EOF
-	if err != nil {
-		this.err = err
-	}
-}
-
-// implement the io.Reader interface
-func (this *Reader) Read(p []byte) (n int, err error) {
-	// checks for when we have no data
-	for this.writePos == 0 || this.readPos == this.writePos {
-		// if we have an error / EOF, just return it
-		if this.err != nil {
-			return n, this.err
-		}
-
-		// else, fill our buffer
-		this.fillBuffer()
-	}
-
-	// TODO: checks for when we have less data than len(p)
-
-	// we should have an appropriate amount of data, convert it into the given buffer
-	bytesRead, bytesWritten, err := this.converter.Convert(this.buffer[this.readPos:this.writePos], p)
-
-	// adjust byte counters
-	this.readPos += bytesRead
-	n += bytesWritten
-
-	// if we experienced an iconv error, check it
-	if err != nil {
-		// E2BIG errors can be ignored (we'll get them often) as long
-		// as at least 1 byte was written. If we experienced an E2BIG
-		// and no bytes were written then the buffer is too small for
-		// even the next character
-		if err != syscall.E2BIG || bytesWritten == 0 {
-			// track anything else
-			this.err = err
-		}
-	}
-
-	// return our results
-	return n, this.err
-}
-
-
-type Writer struct {
-	destination       io.Writer
-	converter         *Converter
-	buffer            []byte
-	readPos, writePos int
-	err               error
-}
-
-func NewWriter(destination io.Writer, fromEncoding string, toEncoding string) (*Writer, error) {
-	// create a converter
-	converter, err := NewConverter(fromEncoding, toEncoding)
-
-	if err == nil {
-		return NewWriterFromConverter(destination, converter), err
-	}
-
-	// return the error
-	return nil, err
-}
-
-func NewWriterFromConverter(destination io.Writer, converter *Converter) (writer *Writer) {
-	writer = new(Writer)
-
-	// copy elements
-	writer.destination = destination
-	writer.converter = converter
-
-	// create 8K buffers
-	writer.buffer = make([]byte, 8*1024)
-
-	return writer
-}
-
-func (this *Writer) emptyBuffer() {
-	// write new data out of buffer
-	bytesWritten, err := this.destination.Write(this.buffer[this.readPos:this.writePos])
-
-	// update read position
-	this.readPos += bytesWritten
-
-	// slide existing data to beginning
-	if this.readPos > 0 {
-		// copy current bytes - is this guaranteed safe?
-		copy(this.buffer, this.buffer[this.readPos:this.writePos])
-
-		// adjust positions
-		this.writePos -= this.readPos
-		this.readPos = 0
-	}
-
-	// track any reader error / EOF
-	if err != nil {
-		this.err = err
-	}
-}
-
-// implement the io.Writer interface
-func (this *Writer) Write(p []byte) (n int, err error) {
-	// write data into our internal buffer
-	bytesRead, bytesWritten, err := this.converter.Convert(p, this.buffer[this.writePos:])
-
-	// update bytes written for return
-	n += bytesRead
-	this.writePos += bytesWritten
-
-	// checks for when we have a full buffer
-	for this.writePos > 0 {
-		// if we have an error, just return it
-		if this.err != nil {
-			return
-		}
-
-		// else empty the buffer
-		this.emptyBuffer()
-	}
-
-	return n, err
-}
\ No newline at end of file
@@ -0,0 +1,537 @@
+package url
+
+/*
+
+Copyright (c) 2013, Donovan Jimenez
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without modification,
+are permitted provided that the following conditions are met:
+
+ * Redistributions of source code must retain the above copyright notice, this
+   list of conditions and the following disclaimer.
+ * Redistributions in binary form must reproduce the above copyright notice,
+   this list of conditions and the following disclaimer in the documentation
+   and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR
+ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON
+ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+*/
+
+import "io"
+import "strings"
+import "syscall"
+
+// Convert bytes from an input byte slice into a give output byte slice
+//
+// As many bytes that can converted and fit into the size of output will be
+// processed and the number of bytes read for input as well as the number of
+// bytes written to output will be returned. If not all converted bytes can fit
+// into output and E2BIG error will also be returned. If input contains an invalid
+// sequence of bytes for the Converter's fromEncoding an EILSEQ error will be
+// returned, unless invalid bytes are substituted, and EINVAL if it ends with an
+// incomplete one
+//
+// For shift based output encodings, any end shift byte sequences can be generated by
+// passing a 0 length byte slice as input. Also passing a 0 length byte slice for output
+// will simply reset the shift state without writing any bytes.
+func (this *Converter) Convert(input []byte, output []byte) (bytesRead int, bytesWritten int, err error) {
+	for {
+		read, written, err := this.convert(input[bytesRead:], output[bytesWritten:])
+
+		// update byte counters
+		bytesRead += read
+		bytesWritten += written
+
+		if err != syscall.EILSEQ || this.substitute == nil {
+			return bytesRead, bytesWritten, err
+		}
+
+		// skip the invalid code unit, writing its substitute in its place
+		unitSize := this.unitSize
+		if unitSize < 1 || unitSize > len(input)-bytesRead {
+			unitSize = 1
+		}
+		replacement := this.substitute(input[bytesRead])
+		if len(replacement) > len(output)-bytesWritten {
+			return bytesRead, bytesWritten, syscall.E2BIG
+		}
+		bytesWritten += copy(output[bytesWritten:], replacement)
+		bytesRead += unitSize
+		this.substituted += int64(unitSize)
+
+		// an empty input or output would flush or reset the shift state
+		if bytesRead == len(input) {
+			return bytesRead, bytesWritten, nil
+		}
+		if bytesWritten == len(output) {
+			return bytesRead, bytesWritten, syscall.E2BIG
+		}
+	}
+}
+
+// Substitute makes the Converter skip invalid input bytes rather than fail
+// with EILSEQ, writing the output of substitute, which may be empty, for each
+// of them. The output must already be in the Converter's toEncoding. For
+// UTF-16 and UTF-32 a whole code unit is skipped at once, and substitute is
+// given its first byte
+func (this *Converter) Substitute(substitute func(invalid byte) []byte) {
+	this.substitute = substitute
+}
+
+// Substituted returns the number of invalid input bytes skipped so far
+func (this *Converter) Substituted() int64 {
+	return this.substituted
+}
+
+// codeUnitSize returns the size of the code units of an encoding, 2 for UTF-16
+// and 4 for UTF-32, whose invalid input is skipped a unit at a time to keep
+// the rest of the input aligned, and 1 for any other
+func codeUnitSize(encoding string) int {
+	encoding = strings.NewReplacer("-", "", "_", "").Replace(strings.ToUpper(encoding))
+	switch {
+	case strings.HasPrefix(encoding, "UTF16"), strings.HasPrefix(encoding, "UCS2"):
+		return 2
+	case strings.HasPrefix(encoding, "UTF32"), strings.HasPrefix(encoding, "UCS4"):
+		return 4
+	}
+	return 1
+}
+
+// Convert an input string
+//
+// EILSEQ error may be returned if input contains invalid bytes for the
+// Converter's fromEncoding, unless they are substituted.
+func (this *Converter) ConvertString(input string) (output string, err error) {
+	// make sure we are still open
+	if this.open {
+		// construct the buffers
+		inputBuffer := []byte(input)
+		outputBuffer := make([]byte, len(inputBuffer)*2) // we use a larger buffer to help avoid resizing later
+
+		// call Convert until all input bytes are read or an error occurs
+		var bytesRead, totalBytesRead, bytesWritten, totalBytesWritten int
+
+		for totalBytesRead < len(inputBuffer) && err == nil {
+			// use the totals to create buffer slices
+			bytesRead, bytesWritten, err = this.Convert(inputBuffer[totalBytesRead:], outputBuffer[totalBytesWritten:])
+
+			totalBytesRead += bytesRead
+			totalBytesWritten += bytesWritten
+
+			// check for the E2BIG error specifically, we can add to the output
+			// buffer to correct for it and then continue
+			if err == syscall.E2BIG {
+				// increase the size of the output buffer by another input length
+				// first, create a new buffer
+				tempBuffer := make([]byte, len(outputBuffer)+len(inputBuffer))
+
+				// copy the existing data
+				copy(tempBuffer, outputBuffer)
+
+				// switch the buffers
+				outputBuffer = tempBuffer
+
+				// forget the error
+				err = nil
+			}
+		}
+
+		if err == nil {
+			// perform a final shift state reset
+			_, bytesWritten, err = this.Convert([]byte{}, outputBuffer[totalBytesWritten:])
+
+			// update total count
+			totalBytesWritten += bytesWritten
+		}
+
+		// construct the final output string
+		output = string(outputBuffer[:totalBytesWritten])
+	} else {
+		err = syscall.EBADF
+	}
+
+	return output, err
+}
+
+// All in one Convert method, rather than requiring the construction of an iconv.Converter
+func Convert(input []byte, output []byte, fromEncoding string, toEncoding string) (bytesRead int, bytesWritten int, err error) {
+	// create a temporary converter
+	converter, err := NewConverter(fromEncoding, toEncoding)
+
+	if err == nil {
+		// call converter's Convert
+		bytesRead, bytesWritten, err = converter.Convert(input, output)
+
+		if err == nil {
+			var shiftBytesWritten int
+
+			// call Convert with a nil input to generate any end shift sequences
+			_, shiftBytesWritten, err = converter.Convert(nil, output[bytesWritten:])
+
+			// add shift bytes to total bytes
+			bytesWritten += shiftBytesWritten
+		}
+
+		// close the converter
+		converter.Close()
+	}
+
+	return
+}
+
+// All in one ConvertString method, rather than requiring the construction of an iconv.Converter
+func ConvertString(input string, fromEncoding string, toEncoding string) (output string, err error) {
+	// create a temporary converter
+	converter, err := NewConverter(fromEncoding, toEncoding)
+
+	if err == nil {
+		// convert the string
+		output, err = converter.ConvertString(input)
+
+		// close the converter
+		converter.Close()
+	}
+
+	return
+}
+
+type Reader struct {
+	source            io.Reader
+	converter         *Converter
+	buffer            []byte
+	readPos, writePos int
+	err               error
+	flushed           bool
+	output            []byte // converted but not yet read, when p was too small
+	outputBuffer      []byte
+}
+
+func NewReader(source io.Reader, fromEncoding string, toEncoding string) (*Reader, error) {
+	// create a converter
+	converter, err := NewConverter(fromEncoding, toEncoding)
+
+	if err == nil {
+		return NewReaderFromConverter(source, converter), err
+	}
+
+	// return the error
+	return nil, err
+}
+
+func NewReaderFromConverter(source io.Reader, converter *Converter) (reader *Reader) {
+	reader = new(Reader)
+
+	// copy elements
+	reader.source = source
+	reader.converter = converter
+
+	// create 8K buffers
+	reader.buffer = make([]byte, 8*1024)
+
+	return reader
+}
+
+func (this *Reader) fillBuffer() {
+	// slide existing data, such as the start of a character, to beginning
+	if this.readPos > 0 {
+		copy(this.buffer, this.buffer[this.readPos:this.writePos])
+
+		// adjust positions
+		this.writePos -= this.readPos
+		this.readPos = 0
+	}
+
+	// read new data into buffer at write position
+	bytesRead, err := this.source.Read(this.buffer[this.writePos:])
+
+	// adjust write position
+	this.writePos += bytesRead
+
+	// track any reader error / EOF
+	if err != nil {
+		this.err = err
+	}
+}
+
+// implement the io.Reader interface
+//
+// When p is too small for the next character it is converted into a buffer
+// of the Reader, and returned over as many calls as it takes
+func (this *Reader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// return what is left of the last character first
+	if len(this.output) > 0 {
+		n = copy(p, this.output)
+		this.output = this.output[n:]
+		return n, nil
+	}
+
+	n, err = this.read(p)
+	if err != syscall.E2BIG {
+		return n, err
+	}
+
+	// p is too small for even the next character
+	if this.outputBuffer == nil {
+		this.outputBuffer = make([]byte, 64)
+	}
+	bytesWritten, err := this.read(this.outputBuffer)
+	n = copy(p, this.outputBuffer[:bytesWritten])
+	this.output = this.outputBuffer[n:bytesWritten]
+	return n, err
+}
+
+// read converts as much data as fits into p, failing with E2BIG if p is too
+// small for even the next character
+func (this *Reader) read(p []byte) (n int, err error) {
+	for {
+		// convert whatever data we have, however little
+		if this.readPos < this.writePos {
+			bytesRead, bytesWritten, err := this.converter.Convert(this.buffer[this.readPos:this.writePos], p)
+
+			// adjust byte counters
+			this.readPos += bytesRead
+			n += bytesWritten
+
+			switch err {
+			case nil:
+			case syscall.EINVAL:
+				// the buffer ends in the middle of a character, which the
+				// next fill completes
+			case syscall.E2BIG:
+				// E2BIG errors can be ignored (we'll get them often) as long
+				// as at least 1 byte was written. If no bytes were written
+				// then p is too small for even the next character
+				if bytesWritten == 0 {
+					return 0, err
+				}
+			default:
+				// anything else ends the stream, after the data before it
+				this.err = err
+			}
+
+			if n > 0 {
+				return n, nil
+			}
+		}
+
+		// if we have an error / EOF, stop reading
+		if this.err != nil {
+			break
+		}
+
+		// else, fill our buffer
+		this.fillBuffer()
+	}
+
+	if this.err == io.EOF {
+		if this.readPos < this.writePos {
+			// the source ended in the middle of a character
+			if this.converter.substitute == nil {
+				return 0, syscall.EINVAL
+			}
+
+			// which is invalid, substitute each of its bytes
+			for this.readPos < this.writePos {
+				replacement := this.converter.substitute(this.buffer[this.readPos])
+				if len(replacement) > len(p)-n {
+					break
+				}
+				n += copy(p[n:], replacement)
+				this.readPos++
+				this.converter.substituted++
+			}
+			if n == 0 && this.readPos < this.writePos {
+				return 0, syscall.E2BIG
+			}
+			return n, nil
+		} else if !this.flushed {
+			// write any end shift sequence
+			_, bytesWritten, err := this.converter.Convert(nil, p)
+			if err == syscall.E2BIG {
+				return 0, err
+			}
+			this.flushed = true
+			if err != nil {
+				this.err = err
+			}
+			if bytesWritten > 0 {
+				return bytesWritten, nil
+			}
+		}
+	}
+
+	return 0, this.err
+}
+
+// Close releases the converter of the Reader, but not its source
+func (this *Reader) Close() error {
+	return this.converter.Close()
+}
+
+type Writer struct {
+	destination       io.Writer
+	converter         *Converter
+	buffer            []byte
+	readPos, writePos int
+	pending           []byte
+	err               error
+}
+
+func NewWriter(destination io.Writer, fromEncoding string, toEncoding string) (*Writer, error) {
+	// create a converter
+	converter, err := NewConverter(fromEncoding, toEncoding)
+
+	if err == nil {
+		return NewWriterFromConverter(destination, converter), err
+	}
+
+	// return the error
+	return nil, err
+}
+
+func NewWriterFromConverter(destination io.Writer, converter *Converter) (writer *Writer) {
+	writer = new(Writer)
+
+	// copy elements
+	writer.destination = destination
+	writer.converter = converter
+
+	// create 8K buffers
+	writer.buffer = make([]byte, 8*1024)
+
+	return writer
+}
+
+func (this *Writer) emptyBuffer() {
+	// write data out of buffer until it is empty or the destination fails
+	for this.readPos < this.writePos && this.err == nil {
+		bytesWritten, err := this.destination.Write(this.buffer[this.readPos:this.writePos])
+
+		// update read position
+		this.readPos += bytesWritten
+
+		// track any writer error
+		if err != nil {
+			this.err = err
+		} else if bytesWritten == 0 {
+			this.err = io.ErrShortWrite
+		}
+	}
+
+	// slide existing data to beginning
+	if this.readPos > 0 {
+		copy(this.buffer, this.buffer[this.readPos:this.writePos])
+
+		// adjust positions
+		this.writePos -= this.readPos
+		this.readPos = 0
+	}
+}
+
+// implement the io.Writer interface
+func (this *Writer) Write(p []byte) (n int, err error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	// complete any character started by the last write
+	input := p
+	carried := len(this.pending)
+	if carried > 0 {
+		input = append(this.pending, p...)
+		this.pending = nil
+	}
+
+	totalBytesRead := 0
+	for totalBytesRead < len(input) {
+		// convert data into our internal buffer
+		bytesRead, bytesWritten, err := this.converter.Convert(input[totalBytesRead:], this.buffer[this.writePos:])
+
+		// update byte counters
+		totalBytesRead += bytesRead
+		this.writePos += bytesWritten
+
+		switch err {
+		case nil:
+		case syscall.E2BIG:
+			// our buffer is full, empty it and carry on
+			if bytesRead == 0 && this.writePos == 0 {
+				return max(totalBytesRead-carried, 0), err
+			}
+			this.emptyBuffer()
+		case syscall.EINVAL:
+			// p ends in the middle of a character, keep its start for the next write
+			this.pending = append([]byte(nil), input[totalBytesRead:]...)
+			totalBytesRead = len(input)
+		default:
+			this.err = err
+		}
+
+		if this.err != nil {
+			return max(totalBytesRead-carried, 0), this.err
+		}
+	}
+
+	// write out what was converted
+	this.emptyBuffer()
+	if this.err != nil {
+		return len(p), this.err
+	}
+
+	return len(p), nil
+}
+
+// Close writes any end shift sequence and releases the converter of the
+// Writer, but does not close its destination. A character left incomplete
+// by the last write is an EINVAL error, unless invalid bytes are substituted.
+func (this *Writer) Close() (err error) {
+	if len(this.pending) > 0 && this.converter.substitute != nil {
+		// which is invalid, substitute each of its bytes
+		for _, invalid := range this.pending {
+			replacement := this.converter.substitute(invalid)
+			if len(replacement) > len(this.buffer)-this.writePos {
+				this.emptyBuffer()
+			}
+			this.writePos += copy(this.buffer[this.writePos:], replacement)
+			this.converter.substituted++
+		}
+		this.pending = nil
+	}
+
+	err = this.err
+	if err == nil && len(this.pending) > 0 {
+		err = syscall.EINVAL
+	}
+
+	if err == nil {
+		// write any end shift sequence
+		var bytesWritten int
+		_, bytesWritten, err = this.converter.Convert(nil, this.buffer[this.writePos:])
+		this.writePos += bytesWritten
+		if err != nil {
+			this.err = err
+		}
+		this.emptyBuffer()
+		err = this.err
+	}
+
+	if closeErr := this.converter.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,253 @@
+//go:build !cgo
+
+package url
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// Converter is the pure Go counterpart of the iconv Converter, used when
+// cgo is unavailable. Text is decoded to UTF-8 with the charset tables of
+// golang.org/x/text and encoded from there, with the errors iconv gives.
+// Only the Unicode encodings and the single byte charsets are known, which
+// read and write exactly as with iconv.
+type Converter struct {
+	decoder *strictDecoder
+	encoder transform.Transformer // nil when converting to UTF-8
+	open    bool
+
+	substitute  func(invalid byte) []byte // for invalid input, rather than EILSEQ
+	substituted int64
+	unitSize    int // of the source encoding, the bytes skipped for invalid input
+}
+
+// errIllegalSequence is reported for input that is not valid in the
+// encoding, which iconv reports as EILSEQ
+var errIllegalSequence = errors.New("illegal byte sequence")
+
+// Initialize a new Converter. If fromEncoding or toEncoding are not supported
+// then an EINVAL error will be returned, as iconv does
+func NewConverter(fromEncoding string, toEncoding string) (converter *Converter, err error) {
+	from, ok := lookupCharset(fromEncoding)
+	if !ok {
+		return nil, syscall.EINVAL
+	}
+	to, ok := lookupCharset(toEncoding)
+	if !ok {
+		return nil, syscall.EINVAL
+	}
+
+	converter = new(Converter)
+	converter.decoder = newStrictDecoder(from)
+	if to.encoding != (utf8Charset{}) {
+		converter.encoder = to.encoding.NewEncoder()
+	}
+	converter.unitSize = codeUnitSize(fromEncoding)
+	converter.open = true
+
+	return converter, nil
+}
+
+// Close a Converter explicitly
+func (this *Converter) Close() (err error) {
+	this.open = false
+
+	return
+}
+
+// convert is Convert with the charset tables alone, failing on any invalid
+// input
+func (this *Converter) convert(input []byte, output []byte) (bytesRead int, bytesWritten int, err error) {
+	// make sure we are still open
+	if !this.open {
+		return 0, 0, syscall.EBADF
+	}
+
+	if len(output) == 0 {
+		// a shift state reset
+		this.reset()
+		return 0, 0, nil
+	}
+	if len(input) == 0 {
+		// write any end shift sequence, and go back to the initial state
+		if this.encoder != nil {
+			bytesWritten, _, err = this.encoder.Transform(output, nil, true)
+		}
+		if err != nil {
+			return 0, bytesWritten, convertError(err)
+		}
+		this.reset()
+		return 0, bytesWritten, nil
+	}
+
+	if this.encoder == nil {
+		bytesWritten, bytesRead, err = this.decoder.Transform(output, input, false)
+		return bytesRead, bytesWritten, convertError(err)
+	}
+
+	// through UTF-8 a character at a time, so that input is only counted as
+	// read once its character is written
+	var utf8Buffer [32]byte
+	for bytesRead < len(input) {
+		decoded, read, err := this.decoder.step(utf8Buffer[:], input[bytesRead:], false)
+		if err != nil {
+			return bytesRead, bytesWritten, convertError(err)
+		}
+		// a byte order mark may be written without the character after it
+		written, _, err := this.encoder.Transform(output[bytesWritten:], utf8Buffer[:decoded], false)
+		bytesWritten += written
+		if err != nil {
+			return bytesRead, bytesWritten, convertError(err)
+		}
+		bytesRead += read
+	}
+
+	return bytesRead, bytesWritten, nil
+}
+
+// reset the shift state of both sides of the conversion
+func (this *Converter) reset() {
+	this.decoder.Reset()
+	if this.encoder != nil {
+		this.encoder.Reset()
+	}
+}
+
+// convertError :: the iconv error for an error of a transformer
+func convertError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case transform.ErrShortDst:
+		return syscall.E2BIG
+	case transform.ErrShortSrc:
+		return syscall.EINVAL
+	}
+	return syscall.EILSEQ
+}
+
+// strictDecoder decodes to UTF-8 and fails on invalid input, where the
+// x/text decoders write U+FFFD
+type strictDecoder struct {
+	decoder transform.Transformer
+	// replacements are the encodings of U+FFFD itself, which are valid input
+	replacements [][]byte
+	stateful     bool
+}
+
+func newStrictDecoder(charset pureCharset) *strictDecoder {
+	decoder := &strictDecoder{
+		decoder:  charset.encoding.NewDecoder(),
+		stateful: charset.stateful,
+	}
+
+	// what follows a character is the encoding of U+FFFD alone, without
+	// any byte order mark written before it
+	encoder := charset.encoding.NewEncoder()
+	prefix, _, err := transform.Bytes(encoder, []byte("a"))
+	if err != nil {
+		return decoder
+	}
+	encoded, _, err := transform.Bytes(encoder, []byte("a\uFFFD"))
+	if err != nil || !bytes.HasPrefix(encoded, prefix) {
+		return decoder
+	}
+	replacement := encoded[len(prefix):]
+	decoder.replacements = append(decoder.replacements, replacement)
+	if charset.bom {
+		// the byte order mark may say the other order
+		swapped := make([]byte, len(replacement))
+		for i := range replacement {
+			swapped[i] = replacement[len(replacement)-1-i]
+		}
+		decoder.replacements = append(decoder.replacements, swapped)
+	}
+
+	return decoder
+}
+
+func (this *strictDecoder) Reset() {
+	this.decoder.Reset()
+}
+
+// implement the transform.Transformer interface
+func (this *strictDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if !this.stateful {
+		// decode all of it, and only go over it again a character at a time
+		// if something was replaced
+		nDst, nSrc, err = this.decoder.Transform(dst, src, atEOF)
+		if !bytes.ContainsRune(dst[:nDst], utf8.RuneError) {
+			return nDst, nSrc, this.fullError(src[nSrc:], atEOF, err)
+		}
+		this.decoder.Reset()
+		nDst, nSrc = 0, 0
+	}
+
+	for nSrc < len(src) {
+		decoded, read, err := this.step(dst[nDst:], src[nSrc:], atEOF)
+		nDst += decoded
+		nSrc += read
+		if err != nil {
+			return nDst, nSrc, this.fullError(src[nSrc:], atEOF, err)
+		}
+		if decoded == 0 && read == 0 {
+			break
+		}
+	}
+
+	return nDst, nSrc, nil
+}
+
+// fullError :: the error for output that is full, which is that of the
+// next character when it is invalid, as iconv looks at it before finding
+// there is no room for it
+func (this *strictDecoder) fullError(src []byte, atEOF bool, err error) error {
+	if err != transform.ErrShortDst || len(src) == 0 {
+		return err
+	}
+	var probe [32]byte
+	if _, _, probeErr := this.step(probe[:], src, atEOF); probeErr == errIllegalSequence {
+		return probeErr
+	}
+
+	return err
+}
+
+// step :: decode the first character of src, growing the input a byte at a
+// time so that exactly the bytes of the character are read
+func (this *strictDecoder) step(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	var char [32]byte
+	decoded := 0
+	for size := 1; size <= len(src); size++ {
+		last := size == len(src)
+		decoded, nSrc, err = this.decoder.Transform(char[:], src[:size], atEOF && last)
+		if err != transform.ErrShortSrc || last {
+			break
+		}
+	}
+
+	if bytes.ContainsRune(char[:decoded], utf8.RuneError) && !this.isReplacement(src[:nSrc]) {
+		return 0, 0, errIllegalSequence
+	}
+	if decoded > len(dst) {
+		return 0, 0, transform.ErrShortDst
+	}
+
+	return copy(dst, char[:decoded]), nSrc, err
+}
+
+// isReplacement :: whether input is U+FFFD encoded, rather than invalid
+func (this *strictDecoder) isReplacement(input []byte) bool {
+	for _, replacement := range this.replacements {
+		if bytes.Equal(input, replacement) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,147 @@
+package url
+
+import (
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+)
+
+// converterCases are conversions as iconv does them, which the pure Go
+// Converter of builds without cgo must do the same. Run with
+// CGO_ENABLED=1 and CGO_ENABLED=0.
+var converterCases = []struct {
+	from, to  string
+	input     string
+	bytesRead int
+	output    string
+	err       error
+}{
+	{"UTF-8", "UTF-8", "café", 5, "café", nil},
+	{"UTF-8", "UTF-8", "a\xffb", 1, "a", syscall.EILSEQ},
+	{"UTF-8", "UTF-8", "a\xc3", 1, "a", syscall.EINVAL},
+	{"UTF-8", "UTF-8", "\xf8\x88\x80\x80", 0, "", syscall.EINVAL},
+	{"UTF-8", "UTF-8", "\xed\xa0\x80", 0, "", syscall.EILSEQ},
+	{"UTF-16", "UTF-8", "\xff\xfea\x00", 4, "a", nil},
+	{"UTF-16", "UTF-8", "\xfe\xff\x00a", 4, "a", nil},
+	{"UTF-16", "UTF-8", "a\x00b\x00", 4, "ab", nil},
+	{"UTF-16LE", "UTF-8", "\x00\xdca\x00", 0, "", syscall.EILSEQ},
+	{"UTF-16BE", "UTF-8", "\xd8=\xde\x00", 4, "😀", nil},
+	{"UTF-32LE", "UTF-8", "\x00\x00\x11\x00", 0, "", syscall.EILSEQ},
+	{"UTF-32", "UTF-8", "\x00\x00\xfe\xff\x00\x00\x00a", 8, "a", nil},
+	{"ASCII", "UTF-8", "a\x80", 1, "a", syscall.EILSEQ},
+	{"TIS-620", "UTF-8", "\xa1\x80", 1, "ก", syscall.EILSEQ},
+	{"TIS-620", "UTF-8", "\xa1\xa0", 1, "ก", syscall.EILSEQ},
+	{"ISO-8859-1", "UTF-8", "\x85\xe9", 2, "\u0085é", nil},
+	{"ISO-8859-7", "UTF-8", "\xae", 0, "", syscall.EILSEQ},
+	{"ISO-8859-15", "UTF-8", "\xa4", 1, "€", nil},
+	{"WINDOWS-1252", "UTF-8", "\x80\x93", 2, "€“", nil},
+	{"WINDOWS-1252", "UTF-8", "\x81", 0, "", syscall.EILSEQ},
+	{"WINDOWS-1251", "UTF-8", "\xcf\xf0", 2, "Пр", nil},
+	{"KOI8-U", "UTF-8", "\xae\xbe", 2, "╝╬", nil},
+	{"MACINTOSH", "UTF-8", "\xc6\xf0", 2, "Δ\ue01e", nil},
+	{"MACCYRILLIC", "UTF-8", "\xff", 1, "¤", nil},
+	{"IBM037", "UTF-8", "\xc1[", 2, "A$", nil},
+	{"IBM866", "UTF-8", "\x8f", 1, "П", nil},
+	{"UTF-8", "WINDOWS-1252", "€", 3, "\x80", nil},
+	{"UTF-8", "ISO-8859-1", "€", 0, "", syscall.EILSEQ},
+	{"UTF-8", "UTF-16", "a", 1, "\xff\xfea\x00", nil},
+	{"UTF-8", "UTF-32", "a", 1, "\xff\xfe\x00\x00a\x00\x00\x00", nil},
+	{"UTF-8", "UTF-16BE", "😀", 4, "\xd8=\xde\x00", nil},
+	{"UTF-8", "ASCII", "é", 0, "", syscall.EILSEQ},
+	{"UTF-8", "MACCYRILLIC", "¤", 2, "\xff", nil},
+}
+
+func TestConverterParity(t *testing.T) {
+	for _, c := range converterCases {
+		converter, err := NewConverter(c.from, c.to)
+		if err != nil {
+			t.Errorf("%s to %s: %v", c.from, c.to, err)
+			continue
+		}
+		output := make([]byte, 64)
+		bytesRead, bytesWritten, err := converter.Convert([]byte(c.input), output)
+		if err == nil {
+			// with any end shift sequence or byte order mark
+			var shiftBytesWritten int
+			_, shiftBytesWritten, err = converter.Convert(nil, output[bytesWritten:])
+			bytesWritten += shiftBytesWritten
+		}
+		converter.Close()
+
+		if bytesRead != c.bytesRead || string(output[:bytesWritten]) != c.output || err != c.err {
+			t.Errorf("%s to %s of %q: read %d, wrote %q, %v; want %d, %q, %v", c.from, c.to, c.input, bytesRead, output[:bytesWritten], err, c.bytesRead, c.output, c.err)
+		}
+	}
+}
+
+func TestConverterUnknownEncoding(t *testing.T) {
+	if _, err := NewConverter("NO-SUCH-ENCODING", "UTF-8"); err != syscall.EINVAL {
+		t.Errorf("unknown encoding: got %v, want EINVAL", err)
+	}
+}
+
+// substituteCases are conversions with invalid input substituted by "?",
+// which skip whole code units of UTF-16 and UTF-32 to stay aligned
+var substituteCases = []struct {
+	from        string
+	input       string
+	output      string
+	substituted int64
+}{
+	{"UTF-8", "a\xffb", "a?b", 1},
+	{"UTF-8", "\xff\xfeb", "??b", 2},
+	{"WINDOWS-1252", "\x81x", "?x", 1},
+	{"UTF-16LE", "a\x00\x00\xdcb\x00c\x00", "a?bc", 2},
+	{"UTF-16LE", "a\x00\x00\xd8b\x00c\x00", "a?bc", 2},
+	{"UTF-16BE", "\x00a\xdc\x00\x00b", "a?b", 2},
+	{"UTF-32LE", "a\x00\x00\x00\x00\x00\x11\x00b\x00\x00\x00", "a?b", 4},
+}
+
+func TestConverterSubstitute(t *testing.T) {
+	for _, c := range substituteCases {
+		converter, err := NewConverter(c.from, "UTF-8")
+		if err != nil {
+			t.Errorf("%s: %v", c.from, err)
+			continue
+		}
+		converter.Substitute(func(byte) []byte { return []byte("?") })
+		output, err := converter.ConvertString(c.input)
+		if output != c.output || converter.Substituted() != c.substituted || err != nil {
+			t.Errorf("%s of %q: wrote %q, substituted %d, %v; want %q, %d", c.from, c.input, output, converter.Substituted(), err, c.output, c.substituted)
+		}
+		converter.Close()
+	}
+}
+
+// readerCases are conversions read a byte at a time, smaller than the
+// characters written
+var readerCases = []struct {
+	from, to string
+	input    string
+	output   string
+}{
+	{"WINDOWS-1252", "UTF-8", "caf\xe9 \x80", "café €"},
+	{"UTF-8", "UTF-8", "a😀b", "a😀b"},
+	{"UTF-16LE", "UTF-8", "a\x00\x00\xdcb\x00c", "a?b?"},
+	{"UTF-8", "UTF-16LE", "aé", "a\x00\xe9\x00"},
+	{"UTF-8", "UTF-32BE", "😀", "\x00\x01\xf6\x00"},
+}
+
+func TestReaderSmallBuffer(t *testing.T) {
+	for _, c := range readerCases {
+		converter, err := NewConverter(c.from, c.to)
+		if err != nil {
+			t.Errorf("%s to %s: %v", c.from, c.to, err)
+			continue
+		}
+		converter.Substitute(func(byte) []byte { return []byte("?") })
+		reader := NewReaderFromConverter(strings.NewReader(c.input), converter)
+		output, err := io.ReadAll(iotest.OneByteReader(reader))
+		if string(output) != c.output || err != nil {
+			t.Errorf("%s to %s of %q: read %q, %v; want %q", c.from, c.to, c.input, output, err, c.output)
+		}
+		reader.Close()
+	}
+}
@@ -0,0 +1,200 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// i_sniff_bytes is how much of the data is looked at to recognise its format
+const i_sniff_bytes = 512
+
+// Decoder turns the data of a table URL into rows. The columns of the rows,
+// and the types inferred from a sample of them, are the schema of the table.
+type Decoder interface {
+	// Rows starts reading rows from the data, which the caller closes
+	Rows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error)
+}
+
+// decoderFunc adapts a function to the Decoder interface
+type decoderFunc func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error)
+
+func (f decoderFunc) Rows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+	return f(ctx, table, r, s_url)
+}
+
+// rangeDecoder is implemented by decoders of formats that are not read as a
+// stream, but fetched in parts, and that describe their own schema
+type rangeDecoder interface {
+	Schema(ctx context.Context, table tableConfig) ([]string, map[string]string, error)
+	List(ctx context.Context, d *plugin.QueryData, table tableConfig) error
+}
+
+// tableSplitter is implemented by decoders of formats holding several
+// tables, such as workbooks, which are configured as one table each
+type tableSplitter interface {
+	Split(ctx context.Context, table tableConfig, r io.Reader, s_url string) ([]tableConfig, error)
+}
+
+// formatSpec registers a Decoder for the format option and for detection
+type formatSpec struct {
+	name       string
+	aliases    []string
+	mimeTypes  []string
+	extensions []string
+	text       bool // read through the text reader, which applies max_bytes
+	lines      bool // each row is a line, so max_bytes stops at a line end
+	comments   bool // lines starting with the comment option are dropped
+	sniff      func(table tableConfig, head []byte) bool
+	decoder    Decoder
+}
+
+// formats are the registered formats, by name, alias, MIME type and extension
+var formats = struct {
+	names       []string
+	byName      map[string]*formatSpec
+	byMIME      map[string]*formatSpec
+	byExtension map[string]*formatSpec
+	sniffed     []*formatSpec
+}{
+	byName:      map[string]*formatSpec{},
+	byMIME:      map[string]*formatSpec{},
+	byExtension: map[string]*formatSpec{},
+}
+
+// registerFormat :: make a format available to the format option and to
+// detection. Each format file registers its own from init.
+func registerFormat(spec formatSpec) {
+	if _, ok := formats.byName[spec.name]; ok {
+		panic("format registered twice: " + spec.name)
+	}
+	formats.names = append(formats.names, spec.name)
+	sort.Strings(formats.names)
+	formats.byName[spec.name] = &spec
+	for _, s_alias := range spec.aliases {
+		formats.byName[s_alias] = &spec
+	}
+	for _, s_mime := range spec.mimeTypes {
+		formats.byMIME[s_mime] = &spec
+	}
+	for _, s_ext := range spec.extensions {
+		formats.byExtension[s_ext] = &spec
+	}
+	if spec.sniff != nil {
+		formats.sniffed = append(formats.sniffed, &spec)
+	}
+}
+
+// tableFormat :: the format of a table before its data is fetched: the
+// format option, or the extension of the URL or archive member. nil means
+// the format is decided by sniffing the data once fetched.
+func tableFormat(table tableConfig) (*formatSpec, error) {
+	if table.Format != nil && *table.Format != "" {
+		spec, ok := formats.byName[strings.ToLower(*table.Format)]
+		if !ok {
+			return nil, fmt.Errorf("table %s: format must be one of %s, got %q", table.Name, strings.Join(formats.names, ", "), *table.Format)
+		}
+		return spec, nil
+	}
+
+	s_path := urlPath(stringValue(table.DataURL))
+	if table.member != "" {
+		s_path = table.member
+	}
+	spec := extensionFormat(s_path)
+	if spec == nil {
+		return nil, nil
+	}
+	if _, ok := spec.decoder.(rangeDecoder); ok && table.member != "" {
+		// ranged formats are read from the URL, not from inside an archive
+		return nil, nil
+	}
+	return spec, nil
+}
+
+// extensionFormat :: the format registered for the extension of a path,
+// looking past a compression extension. nil when there is none.
+func extensionFormat(s_path string) *formatSpec {
+	s_path = strings.ToLower(s_path)
+	s_ext := path.Ext(s_path)
+	if _, ok := compressionExtensions[s_ext]; ok {
+		s_ext = path.Ext(strings.TrimSuffix(s_path, s_ext))
+	}
+	if s_ext == "" {
+		return nil
+	}
+	return formats.byExtension[s_ext]
+}
+
+// sniffFormat :: the format of fetched data whose format option is unset,
+// from the extension of its path, then its Content-Type, then its leading
+// bytes. Data that is not recognised is read as csv.
+func sniffFormat(table tableConfig, data *fetchedData, head []byte) *formatSpec {
+	if spec := extensionFormat(data.Path); spec != nil {
+		return spec
+	}
+	if s_type, _, err := mime.ParseMediaType(data.ContentType); err == nil {
+		if spec, ok := formats.byMIME[strings.ToLower(s_type)]; ok {
+			return spec
+		}
+	}
+	for _, spec := range formats.sniffed {
+		if spec.sniff(table, head) {
+			return spec
+		}
+	}
+	return formats.byName[formatCSV]
+}
+
+// sniffText :: the start of text data, after any byte order mark and
+// leading whitespace
+func sniffText(head []byte) []byte {
+	return bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+}
+
+// peekData :: buffer fetched data so that its first bytes can be looked at
+// without consuming them
+func peekData(data *fetchedData, i_size int) (*fetchedData, []byte) {
+	br := bufio.NewReaderSize(data, i_size)
+	head, _ := br.Peek(i_size)
+	peeked := *data
+	peeked.ReadCloser = &readCloser{Reader: br, closers: []io.Closer{data.ReadCloser}}
+	return &peeked, head
+}
+
+// splitTables :: expand a table whose format holds several tables into one
+// table each. Other tables are returned as is. Only the format option and
+// the extension are used, so that plain data URLs are not fetched twice.
+func splitTables(ctx context.Context, table tableConfig) ([]tableConfig, error) {
+
+	spec, err := tableFormat(table)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return []tableConfig{table}, nil
+	}
+	splitter, ok := spec.decoder.(tableSplitter)
+	if !ok {
+		return []tableConfig{table}, nil
+	}
+
+	body, err := openURL(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	tables, err := splitter.Split(ctx, table, body, body.URL)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	return tables, nil
+}
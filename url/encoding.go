@@ -0,0 +1,270 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Values of the invalid_byte_policy option, for bytes of text data that are
+// not valid in its encoding
+const (
+	invalidFail          = "fail"          // fail the query
+	invalidSkip          = "skip"          // drop them
+	invalidReplace       = "replace"       // write invalid_byte_replacement for each
+	invalidTransliterate = "transliterate" // read each as its windows-1252 character
+)
+
+// byteOrderMarks are the leading bytes that give the encoding of text, the
+// longest first as UTF-32LE starts with the UTF-16LE mark
+var byteOrderMarks = []struct {
+	mark     []byte
+	encoding string
+}{
+	{[]byte{0x00, 0x00, 0xfe, 0xff}, "UTF-32BE"},
+	{[]byte{0xff, 0xfe, 0x00, 0x00}, "UTF-32LE"},
+	{[]byte{0xef, 0xbb, 0xbf}, "UTF-8"},
+	{[]byte{0xfe, 0xff}, "UTF-16BE"},
+	{[]byte{0xff, 0xfe}, "UTF-16LE"},
+}
+
+// xmlEncodingRegex finds the encoding in an XML declaration
+var xmlEncodingRegex = regexp.MustCompile(`^<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+// sourceEncoding :: the character encoding of text data, the length of its
+// byte order mark, and whether the encoding was declared at all. The
+// encoding option wins, then a byte order mark, then the charset of the
+// Content-Type header, then the declaration of an XML document. "" means
+// the data is UTF-8.
+func sourceEncoding(table tableConfig, data *fetchedData, head []byte) (string, int, bool) {
+
+	i_bom := 0
+	s_bom := ""
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(head, bom.mark) {
+			s_bom, i_bom = bom.encoding, len(bom.mark)
+			break
+		}
+	}
+
+	if s_option := strings.TrimSpace(stringValue(table.Encoding)); s_option != "" && !strings.EqualFold(s_option, "auto") {
+		// a mark of the same encoding is still not data
+		if !strings.EqualFold(normalEncoding(s_option), normalEncoding(s_bom)) {
+			i_bom = 0
+		}
+		return normalEncoding(s_option), i_bom, true
+	}
+	if s_bom != "" {
+		return normalEncoding(s_bom), i_bom, true
+	}
+	if _, params, err := mime.ParseMediaType(data.ContentType); err == nil && params["charset"] != "" {
+		return normalEncoding(params["charset"]), 0, true
+	}
+	if match := xmlEncodingRegex.FindSubmatch(head); match != nil {
+		return normalEncoding(string(match[1])), 0, true
+	}
+	return "", 0, false
+}
+
+// decodeText :: transcode fetched text to UTF-8 from its declared encoding
+// or, when it has none, from the encoding detected in a sample of it. A
+// detection less confident than encoding_min_confidence is ignored.
+func decodeText(ctx context.Context, table tableConfig, data *fetchedData, invalid *invalidBytes) (*fetchedData, error) {
+
+	i_min_confidence := i_default_min_confidence
+	if table.EncodingMinConfidence != nil {
+		i_min_confidence = *table.EncodingMinConfidence
+	}
+	if i_min_confidence < 0 || i_min_confidence > 100 {
+		data.Close()
+		return nil, fmt.Errorf("table %s: encoding_min_confidence must be between 0 and 100, got %d", table.Name, i_min_confidence)
+	}
+
+	data, head := peekData(data, i_detect_bytes)
+	s_encoding, i_bom, b_declared := sourceEncoding(table, data, head)
+	if !b_declared {
+		s_detected, i_confidence := detectEncoding(head)
+		switch {
+		case normalEncoding(s_detected) == "":
+		case int64(i_confidence) < i_min_confidence:
+			plugin.Logger(ctx).Warn("data encoding not detected with enough confidence, reading it as UTF-8", "table", table.Name, "encoding", s_detected, "confidence", i_confidence, "encoding_min_confidence", i_min_confidence)
+		case !encodingSupported(normalEncoding(s_detected)):
+			// builds without cgo know fewer encodings than the detector
+			plugin.Logger(ctx).Warn("detected data encoding is not supported, reading it as UTF-8", "table", table.Name, "encoding", s_detected, "confidence", i_confidence)
+		default:
+			plugin.Logger(ctx).Info("detected data encoding", "table", table.Name, "encoding", s_detected, "confidence", i_confidence)
+			s_encoding = normalEncoding(s_detected)
+		}
+	}
+
+	transcoded, err := transcode(data, s_encoding, i_bom, invalid)
+	if err != nil {
+		data.Close()
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	if s_encoding != "" {
+		plugin.Logger(ctx).Debug("transcoding data to UTF-8", "table", table.Name, "encoding", s_encoding)
+	}
+	return transcoded, nil
+}
+
+// normalEncoding :: an encoding name as iconv knows it, or "" for UTF-8
+// and its ASCII subset, which need no conversion
+func normalEncoding(s_encoding string) string {
+	s_encoding = strings.ToUpper(strings.TrimSpace(s_encoding))
+	switch s_encoding {
+	case "", "UTF-8", "UTF8", "US-ASCII", "ASCII":
+		return ""
+	case "LATIN1", "LATIN-1":
+		return "ISO-8859-1"
+	case "GB-18030":
+		return "GB18030"
+	case "ISO-8859-8-I":
+		return "ISO-8859-8"
+	}
+	// detected EBCDIC charsets carry the direction of the text
+	if i_dir := strings.LastIndex(s_encoding, "_"); i_dir > 0 && (strings.HasSuffix(s_encoding, "_RTL") || strings.HasSuffix(s_encoding, "_LTR")) {
+		return s_encoding[:i_dir]
+	}
+	return s_encoding
+}
+
+// encodingSupported :: whether text in an encoding can be transcoded to UTF-8
+func encodingSupported(s_encoding string) bool {
+	converter, err := NewConverter(s_encoding, "UTF-8")
+	if err != nil {
+		return false
+	}
+	converter.Close()
+	return true
+}
+
+// transcode :: convert text data to UTF-8 from its source encoding, after
+// dropping any byte order mark, with invalid bytes handled by the policy of
+// invalid. UTF-8 data is returned as is.
+func transcode(data *fetchedData, s_encoding string, i_bom int, invalid *invalidBytes) (*fetchedData, error) {
+
+	var source io.Reader = data
+	if i_bom > 0 {
+		if _, err := io.CopyN(io.Discard, data, int64(i_bom)); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", data.URL, err)
+		}
+	}
+	if s_encoding == "" {
+		return data, nil
+	}
+
+	converter, err := NewConverter(s_encoding, "UTF-8")
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q for %s", s_encoding, data.URL)
+	}
+	if invalid.policy != invalidFail {
+		converter.Substitute(invalid.substitute)
+	}
+	invalid.converter = converter
+	reader := NewReaderFromConverter(source, converter)
+	transcoded := *data
+	transcoded.ReadCloser = &readCloser{
+		Reader:  reader,
+		closers: []io.Closer{reader, data.ReadCloser},
+	}
+	return &transcoded, nil
+}
+
+// invalidBytes applies the invalid_byte_policy of a table to the bytes of
+// its data that are not valid text, and counts those it substitutes
+type invalidBytes struct {
+	policy      string
+	replacement string
+	count       int64
+	converter   *Converter // transcoding the data, which counts its own
+}
+
+// newInvalidBytes :: validate the invalid_byte_policy and
+// invalid_byte_replacement options. The policy defaults to skip and the
+// replacement to U+FFFD.
+func newInvalidBytes(table tableConfig) (*invalidBytes, error) {
+
+	invalid := &invalidBytes{policy: invalidSkip, replacement: "\uFFFD"}
+	if s_policy := strings.ToLower(stringValue(table.InvalidBytePolicy)); s_policy != "" {
+		switch s_policy {
+		case invalidFail, invalidSkip, invalidReplace, invalidTransliterate:
+			invalid.policy = s_policy
+		default:
+			return nil, fmt.Errorf("table %s: invalid_byte_policy must be one of fail, skip, replace or transliterate, got %q", table.Name, s_policy)
+		}
+	}
+	if table.InvalidByteReplacement != nil {
+		if utf8.RuneCountInString(*table.InvalidByteReplacement) != 1 || !utf8.ValidString(*table.InvalidByteReplacement) {
+			return nil, fmt.Errorf("table %s: invalid_byte_replacement must be a single character, got %q", table.Name, *table.InvalidByteReplacement)
+		}
+		invalid.replacement = *table.InvalidByteReplacement
+	}
+	return invalid, nil
+}
+
+// Count :: the number of invalid bytes substituted so far, while
+// transcoding or in UTF-8 text
+func (invalid *invalidBytes) Count() int64 {
+	if invalid.converter != nil {
+		return invalid.count + invalid.converter.Substituted()
+	}
+	return invalid.count
+}
+
+// log :: warn of the invalid bytes substituted in the data of a fetch, so
+// that data quality issues show in the log
+func (invalid *invalidBytes) log(ctx context.Context, table tableConfig) {
+	if i_count := invalid.Count(); i_count > 0 {
+		plugin.Logger(ctx).Warn("invalid bytes substituted in data", "table", table.Name, "invalid_byte_policy", invalid.policy, "invalid_bytes", i_count)
+	}
+}
+
+// substitute :: the UTF-8 text written in place of an invalid byte, empty
+// when it is skipped. Bytes that windows-1252 leaves undefined are
+// replaced when transliterating.
+func (invalid *invalidBytes) substitute(b byte) []byte {
+	switch invalid.policy {
+	case invalidReplace:
+		return []byte(invalid.replacement)
+	case invalidTransliterate:
+		if r := charmap.Windows1252.DecodeByte(b); r != utf8.RuneError {
+			return []byte(string(r))
+		}
+		return []byte(invalid.replacement)
+	}
+	return nil
+}
+
+// sanitize :: UTF-8 text with its invalid bytes substituted, or an error
+// for the first of them with the fail policy
+func (invalid *invalidBytes) sanitize(s string) (string, error) {
+
+	if utf8.ValidString(s) {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, i_size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && i_size == 1 {
+			if invalid.policy == invalidFail {
+				return "", fmt.Errorf("invalid UTF-8 byte 0x%02x", s[i])
+			}
+			sb.Write(invalid.substitute(s[i]))
+			invalid.count++
+			i++
+			continue
+		}
+		sb.WriteString(s[i : i+i_size])
+		i += i_size
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,71 @@
+package url
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// invalidByteCases read unlabelled CSV, or CSV in a given encoding, holding
+// one invalid byte in the name of its second row
+var invalidByteCases = []struct {
+	policy   string
+	encoding string
+	data     string
+	name     string // of the second row, "" when the query fails
+}{
+	{"skip", "", "id,name\n1,café\n2,b\xffd\n", "bd"},
+	{"replace", "", "id,name\n1,café\n2,b\xffd\n", "b�d"},
+	{"transliterate", "", "id,name\n1,café\n2,b\xffd\n", "bÿd"},
+	{"fail", "", "id,name\n1,café\n2,b\xffd\n", ""},
+	{"skip", "windows-1252", "id,name\n1,caf\xe9\n2,b\x81d\n", "bd"},
+	{"replace", "windows-1252", "id,name\n1,caf\xe9\n2,b\x81d\n", "b�d"},
+	{"fail", "windows-1252", "id,name\n1,caf\xe9\n2,b\x81d\n", ""},
+}
+
+func TestInvalidBytePolicy(t *testing.T) {
+	for _, c := range invalidByteCases {
+		s_path := filepath.Join(t.TempDir(), "data.csv")
+		if err := os.WriteFile(s_path, []byte(c.data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		s_url := "file://" + s_path
+		policy, encoding := c.policy, c.encoding
+		table := tableConfig{Name: "t", DataURL: &s_url, InvalidBytePolicy: &policy, Encoding: &encoding}
+
+		var names []string
+		var counts []int64
+		stream, err := openRows(context.Background(), table)
+		if err == nil {
+			for {
+				var sm_row map[string]interface{}
+				if sm_row, err = stream.Next(); err != nil {
+					break
+				}
+				names = append(names, sm_row["name"].(string))
+				counts = append(counts, stream.invalid.Count())
+			}
+			stream.Close()
+		}
+		if c.name == "" {
+			if err == io.EOF {
+				t.Errorf("%s %s: read %q, want an error", c.policy, c.encoding, names)
+			}
+			continue
+		}
+		if err != io.EOF {
+			t.Errorf("%s %s: %v", c.policy, c.encoding, err)
+			continue
+		}
+		if len(names) != 2 || names[0] != "café" || names[1] != c.name {
+			t.Errorf("%s %s: read %q, want [\"café\" %q]", c.policy, c.encoding, names, c.name)
+		}
+		// _invalid_bytes counts the bytes substituted in the data read so
+		// far, which is the total of the fetch by the last row
+		if len(counts) != 2 || counts[0] > counts[1] || counts[1] != 1 {
+			t.Errorf("%s %s: invalid byte counts %v, want 1 by the last row", c.policy, c.encoding, counts)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+)
+
+// bodySnippetBytes is how much of an error response is quoted back
+const bodySnippetBytes = 512
+
+// fetchError is returned when a data URL answers with a non-2xx status
+type fetchError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Snippet    string
+}
+
+func (e *fetchError) Error() string {
+	if e.Snippet == "" {
+		return fmt.Sprintf("GET %s returned %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("GET %s returned %s: %s", e.URL, e.Status, e.Snippet)
+}
+
+// fetchedData is an open response body along with what the response says
+// about its content
+type fetchedData struct {
+	io.ReadCloser
+	URL             string
+	Path            string // URL path, for extension based detection
+	ContentType     string
+	ContentEncoding string
+}
+
+// fetchURL :: GET the table URL and return the response body. Transient
+// failures are retried with backoff, other failed requests and non-2xx
+// responses are returned as errors naming the URL. The caller must close
+// the body.
+func fetchURL(ctx context.Context, table tableConfig) (*fetchedData, error) {
+
+	var s_url string
+	if table.DataURL != nil {
+		s_url = *table.DataURL
+	}
+	if s_url == "" {
+		return nil, fmt.Errorf("table %s has no dataURL", table.Name)
+	}
+
+	settings, err := tableRetrySettings(table)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	timeout, err := durationOption(table.Timeout, "timeout", 0)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	readTimeout, err := durationOption(table.ReadTimeout, "read_timeout", defaultReadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+
+	// the request context lives as long as the body, it is cancelled on
+	// Close or when the overall timeout passes
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	resp, err := getWithRetries(ctx, table, s_url, nil, settings)
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("GET %s did not complete within timeout of %s", s_url, timeout)
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer cancel()
+		defer resp.Body.Close()
+		return nil, &fetchError{
+			URL:        s_url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Snippet:    bodySnippet(resp.Body),
+		}
+	}
+
+	return &fetchedData{
+		ReadCloser:      newTimeoutBody(resp.Body, s_url, readTimeout, cancel),
+		URL:             s_url,
+		Path:            resp.Request.URL.Path,
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
+	}, nil
+}
+
+// getWithRetries :: GET a data URL with the fetcher of its scheme, retrying
+// transient failures with backoff. The last response or error is returned
+// once retries run out.
+func getWithRetries(ctx context.Context, table tableConfig, s_url string, header http.Header, settings retrySettings) (*http.Response, error) {
+	fetcher, err := urlFetcher(s_url)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	for attempt := int64(0); ; attempt++ {
+		resp, err := fetcher.Get(ctx, table, s_url, header)
+
+		s_reason := retryReason(ctx, resp, err)
+		if s_reason == "" || attempt >= settings.maxRetries {
+			return resp, err
+		}
+		delay := retryDelay(settings, resp, attempt)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		plugin.Logger(ctx).Warn("fetchURL retrying", "table", table.Name, "url", s_url, "attempt", attempt+1, "max_retries", settings.maxRetries, "delay", delay.String(), "reason", s_reason)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, fmt.Errorf("GET %s: %v", s_url, err)
+		}
+	}
+}
+
+// openURL :: fetchURL, decompressing the body as configured. For a table
+// read from an archive member the member is opened, and decompressed in
+// turn if needed. Zip members are read from a download of the archive, see
+// zipDownload.
+func openURL(ctx context.Context, table tableConfig) (*fetchedData, error) {
+	if table.member != "" && table.archive == archiveZip {
+		member, err := openZipMember(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		decompressed, err := decompress(member, "auto")
+		if err != nil {
+			member.Close()
+			return nil, fmt.Errorf("table %s: %v", table.Name, err)
+		}
+		return decompressed, nil
+	}
+
+	data, err := fetchURL(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := decompress(data, stringValue(table.Compression))
+	if err != nil {
+		data.Close()
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	if table.member == "" {
+		return decompressed, nil
+	}
+
+	member, err := openTarMember(decompressed, table.member)
+	if err != nil {
+		decompressed.Close()
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	decompressed, err = decompress(member, "auto")
+	if err != nil {
+		member.Close()
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	return decompressed, nil
+}
+
+// httpFetcher fetches http and https URLs, with the configured
+// authentication and HTTP client settings
+type httpFetcher struct{}
+
+func (httpFetcher) Get(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error) {
+	return getAuthorizedURL(ctx, table, s_url, header)
+}
+
+// getAuthorizedURL :: getURL, renewing the OAuth2 access token and trying
+// once more if the server answers 401
+func getAuthorizedURL(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error) {
+	resp, err := getURL(ctx, table, s_url, header, false)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && oauth2Enabled(table) {
+		// the access token may have been revoked early, renew it and try once more
+		plugin.Logger(ctx).Info("fetchURL retrying with a new oauth2 token", "table", table.Name)
+		resp.Body.Close()
+		resp, err = getURL(ctx, table, s_url, header, true)
+	}
+	return resp, err
+}
+
+// getURL :: send a single authenticated GET for the table URL, with any
+// extra request headers such as Range
+func getURL(ctx context.Context, table tableConfig, s_url string, header http.Header, renewToken bool) (*http.Response, error) {
+
+	req, err := newRequest(ctx, s_url, header)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyAuth(req, table); err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	if oauth2Enabled(table) {
+		s_token, err := oauth2AccessToken(ctx, table, renewToken)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %v", table.Name, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+s_token)
+	}
+
+	client, err := httpClient(table)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the client error already names the URL, report its cause only
+		if cause := errors.Unwrap(err); cause != nil {
+			err = cause
+		}
+		return nil, fmt.Errorf("GET %s failed: %w", s_url, err)
+	}
+	return resp, nil
+}
+
+// bodySnippet :: the start of a response body on a single line, for use in
+// error messages
+func bodySnippet(body io.Reader) string {
+	buff, _ := io.ReadAll(io.LimitReader(body, bodySnippetBytes))
+	s_snippet := strings.Join(strings.Fields(strings.ToValidUTF8(string(buff), "\uFFFD")), " ")
+	if len(buff) == bodySnippetBytes {
+		s_snippet += " ..."
+	}
+	return s_snippet
+}
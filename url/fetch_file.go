@@ -0,0 +1,103 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// fileFetcher reads file:// URLs from the local file system, which is handy
+// to try a configuration out on a downloaded copy of the data
+type fileFetcher struct{}
+
+func (fileFetcher) Get(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error) {
+
+	req, err := newRequest(ctx, s_url, header)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Host != "" && req.URL.Host != "localhost" || req.URL.Path == "" {
+		return nil, fmt.Errorf("invalid dataURL %q: file URLs must give an absolute local path, as file:///path", s_url)
+	}
+
+	f, err := os.Open(req.URL.Path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return statusResponse(req, http.StatusNotFound, err.Error()), nil
+	case errors.Is(err, fs.ErrPermission):
+		return statusResponse(req, http.StatusForbidden, err.Error()), nil
+	case err != nil:
+		return nil, fmt.Errorf("GET %s failed: %v", s_url, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("GET %s failed: %v", s_url, err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return statusResponse(req, http.StatusNotFound, req.URL.Path+" is a directory"), nil
+	}
+	// local files carry no content type, the extension and the data tell
+	return contentResponse(req, f, info.Size(), "")
+}
+
+// dataFetcher reads data: URLs, which hold their data inline as in
+// data:text/csv;base64,aWQsbmFtZQ==
+type dataFetcher struct{}
+
+func (dataFetcher) Get(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error) {
+
+	req, err := newRequest(ctx, s_url, header)
+	if err != nil {
+		return nil, err
+	}
+	s_meta, s_data, ok := strings.Cut(s_url[len("data:"):], ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid dataURL: data URLs must have a comma before the data")
+	}
+	b_base64 := false
+	if s_type, ok := strings.CutSuffix(s_meta, ";base64"); ok {
+		s_meta = s_type
+		b_base64 = true
+	}
+	if s_meta == "" || strings.HasPrefix(s_meta, ";") {
+		s_meta = "text/plain" + s_meta
+	}
+
+	s_decoded, err := url.PathUnescape(s_data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dataURL: %v", err)
+	}
+	buff := []byte(s_decoded)
+	if b_base64 {
+		s_encoded := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+				return -1
+			}
+			return r
+		}, s_decoded)
+		if buff, err = base64.StdEncoding.DecodeString(s_encoded); err != nil {
+			if buff, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(s_encoded, "=")); err != nil {
+				return nil, fmt.Errorf("invalid dataURL: base64 data: %v", err)
+			}
+		}
+	}
+	return contentResponse(req, nopSeekCloser{bytes.NewReader(buff)}, int64(len(buff)), s_meta)
+}
+
+// nopSeekCloser gives in memory data the Close of a file
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,256 @@
+package url
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s_empty_sha256 is the SHA-256 of an empty payload, the body of every GET
+const s_empty_sha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Fetcher reads s3://bucket/key URLs from AWS S3 or any S3 compatible
+// endpoint such as MinIO, signing requests with AWS Signature Version 4
+type s3Fetcher struct{}
+
+// awsCredentials sign requests, and are empty for anonymous access to
+// public buckets
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+func (s3Fetcher) Get(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error) {
+
+	s_http, s_region, err := s3HTTPURL(table, s_url)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	creds, err := s3Credentials(table)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+
+	req, err := newRequest(ctx, s_http, header)
+	if err != nil {
+		return nil, err
+	}
+	if creds.accessKeyID != "" {
+		signV4(req, creds, s_region, "s3", time.Now().UTC())
+	}
+
+	client, err := httpClient(table)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cause := errors.Unwrap(err); cause != nil {
+			err = cause
+		}
+		return nil, fmt.Errorf("GET %s failed: %w", s_url, err)
+	}
+	return resp, nil
+}
+
+// s3HTTPURL :: the HTTP URL of an object, and the region to sign for. The
+// endpoint is s3_endpoint, or AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL,
+// defaulting to AWS in the region. Custom endpoints are addressed by path,
+// AWS by bucket host name, unless s3_path_style says otherwise.
+func s3HTTPURL(table tableConfig, s_url string) (string, string, error) {
+
+	u, err := url.Parse(s_url)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid dataURL %q: %v", s_url, err)
+	}
+	s_bucket := u.Host
+	s_key := strings.TrimPrefix(u.Path, "/")
+	if s_bucket == "" || s_key == "" {
+		return "", "", fmt.Errorf("invalid dataURL %q: S3 URLs must be s3://bucket/key", s_url)
+	}
+
+	s_region := firstSet(stringValue(table.S3Region), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	s_endpoint := firstSet(stringValue(table.S3Endpoint), os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	b_path_style := s_endpoint != ""
+	if table.S3PathStyle != nil {
+		b_path_style = *table.S3PathStyle
+	}
+	if s_endpoint == "" {
+		s_endpoint = "https://s3." + s_region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(s_endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return "", "", fmt.Errorf("s3_endpoint must be an http or https URL, got %q", s_endpoint)
+	}
+	// bucket names with dots do not match the certificate of AWS host names
+	if strings.Contains(s_bucket, ".") && endpoint.Scheme == "https" {
+		b_path_style = true
+	}
+
+	s_host := endpoint.Host
+	s_path := strings.TrimRight(endpoint.Path, "/") + "/" + s_bucket + "/" + s_key
+	if !b_path_style {
+		s_host = s_bucket + "." + endpoint.Host
+		s_path = strings.TrimRight(endpoint.Path, "/") + "/" + s_key
+	}
+	s_http := endpoint.Scheme + "://" + s_host + awsURIEncode(s_path, false)
+	if u.RawQuery != "" {
+		s_http += "?" + u.RawQuery
+	}
+	return s_http, s_region, nil
+}
+
+// s3Credentials :: the s3_access_key_id and s3_secret_access_key options,
+// or the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables. Without either, requests are sent unsigned.
+func s3Credentials(table tableConfig) (awsCredentials, error) {
+
+	s_key_id, err := optionalSecret(table.S3AccessKeyID, "s3_access_key_id")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if s_key_id == "" {
+		return awsCredentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	s_secret, err := optionalSecret(table.S3SecretAccessKey, "s3_secret_access_key")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if s_secret == "" {
+		return awsCredentials{}, fmt.Errorf("s3_secret_access_key must be set along with s3_access_key_id")
+	}
+	s_token, err := optionalSecret(table.S3SessionToken, "s3_session_token")
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{accessKeyID: s_key_id, secretAccessKey: s_secret, sessionToken: s_token}, nil
+}
+
+// signV4 :: sign a bodiless request with AWS Signature Version 4, covering
+// the host, any Range and the x-amz-* headers
+func signV4(req *http.Request, creds awsCredentials, s_region string, s_service string, now time.Time) {
+
+	s_amz_date := now.Format("20060102T150405Z")
+	s_date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", s_amz_date)
+	req.Header.Set("X-Amz-Content-Sha256", s_empty_sha256)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	s_host := req.Host
+	if s_host == "" {
+		s_host = req.URL.Host
+	}
+	sm_headers := map[string]string{"host": s_host}
+	for s_name, sa_values := range req.Header {
+		s_lower := strings.ToLower(s_name)
+		if s_lower != "range" && !strings.HasPrefix(s_lower, "x-amz-") {
+			continue
+		}
+		sa_trimmed := make([]string, len(sa_values))
+		for idx, s_value := range sa_values {
+			sa_trimmed[idx] = strings.Join(strings.Fields(s_value), " ")
+		}
+		sm_headers[s_lower] = strings.Join(sa_trimmed, ",")
+	}
+	sa_names := make([]string, 0, len(sm_headers))
+	for s_name := range sm_headers {
+		sa_names = append(sa_names, s_name)
+	}
+	sort.Strings(sa_names)
+	var sb_headers strings.Builder
+	for _, s_name := range sa_names {
+		sb_headers.WriteString(s_name + ":" + sm_headers[s_name] + "\n")
+	}
+	s_signed := strings.Join(sa_names, ";")
+
+	s_canonical := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		sb_headers.String(),
+		s_signed,
+		s_empty_sha256,
+	}, "\n")
+	s_scope := s_date + "/" + s_region + "/" + s_service + "/aws4_request"
+	sum := sha256.Sum256([]byte(s_canonical))
+	s_to_sign := "AWS4-HMAC-SHA256\n" + s_amz_date + "\n" + s_scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), s_date)
+	key = hmacSHA256(key, s_region)
+	key = hmacSHA256(key, s_service)
+	key = hmacSHA256(key, "aws4_request")
+	s_signature := hex.EncodeToString(hmacSHA256(key, s_to_sign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.accessKeyID+"/"+s_scope+", SignedHeaders="+s_signed+", Signature="+s_signature)
+}
+
+func hmacSHA256(key []byte, s_data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s_data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery :: query parameters sorted and encoded as SigV4 expects
+func canonicalQuery(values url.Values) string {
+	sm_encoded := map[string][]string{}
+	var sa_keys []string
+	for s_key, sa_values := range values {
+		s_encoded := awsURIEncode(s_key, true)
+		sa_keys = append(sa_keys, s_encoded)
+		for _, s_value := range sa_values {
+			sm_encoded[s_encoded] = append(sm_encoded[s_encoded], awsURIEncode(s_value, true))
+		}
+		sort.Strings(sm_encoded[s_encoded])
+	}
+	sort.Strings(sa_keys)
+	var sa_pairs []string
+	for _, s_key := range sa_keys {
+		for _, s_value := range sm_encoded[s_key] {
+			sa_pairs = append(sa_pairs, s_key+"="+s_value)
+		}
+	}
+	return strings.Join(sa_pairs, "&")
+}
+
+// awsURIEncode :: percent encode all but the unreserved characters, and
+// slashes in paths
+func awsURIEncode(s_value string, b_encode_slash bool) string {
+	var sb strings.Builder
+	for _, c := range []byte(s_value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !b_encode_slash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// firstSet :: the first of the values that is not empty
+func firstSet(sa_values ...string) string {
+	for _, s_value := range sa_values {
+		if s_value != "" {
+			return s_value
+		}
+	}
+	return ""
+}
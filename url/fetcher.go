@@ -0,0 +1,173 @@
+package url
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fetcher sends the GET requests for data URLs of one scheme. Whatever the
+// scheme, the answer is an HTTP response, so that retries, range reads,
+// timeouts and errors are handled alike for every URL.
+type Fetcher interface {
+	// Get requests the URL with extra headers such as Range. Failures that
+	// have a status, such as a missing file, are returned as responses.
+	Get(ctx context.Context, table tableConfig, s_url string, header http.Header) (*http.Response, error)
+}
+
+// fetchers are the Fetcher of each supported URL scheme
+var fetchers = map[string]Fetcher{
+	"http":  httpFetcher{},
+	"https": httpFetcher{},
+	"file":  fileFetcher{},
+	"data":  dataFetcher{},
+	"s3":    s3Fetcher{},
+}
+
+// urlFetcher :: the Fetcher for the scheme of a data URL
+func urlFetcher(s_url string) (Fetcher, error) {
+	s_scheme := ""
+	if i_colon := strings.Index(s_url, ":"); i_colon > 0 {
+		s_scheme = strings.ToLower(s_url[:i_colon])
+	}
+	fetcher, ok := fetchers[s_scheme]
+	if !ok {
+		sa_schemes := make([]string, 0, len(fetchers))
+		for s_name := range fetchers {
+			sa_schemes = append(sa_schemes, s_name)
+		}
+		sort.Strings(sa_schemes)
+		return nil, fmt.Errorf("unsupported scheme in dataURL %q, use one of %s", s_url, strings.Join(sa_schemes, ", "))
+	}
+	return fetcher, nil
+}
+
+// urlPath :: the path of a data URL, for extension based detection. data:
+// URLs have none.
+func urlPath(s_url string) string {
+	u, err := url.Parse(s_url)
+	if err != nil {
+		// fall back on the text up to any query
+		if i_query := strings.IndexAny(s_url, "?#"); i_query >= 0 {
+			return s_url[:i_query]
+		}
+		return s_url
+	}
+	return u.Path
+}
+
+// contentResponse :: answer a request for content held locally, serving a
+// single byte range when the request asks for one
+func contentResponse(req *http.Request, content io.ReadSeekCloser, i_size int64, s_type string) (*http.Response, error) {
+
+	resp := statusResponse(req, http.StatusOK, "")
+	resp.Body = content
+	resp.ContentLength = i_size
+	resp.Header.Set("Accept-Ranges", "bytes")
+	if s_type != "" {
+		resp.Header.Set("Content-Type", s_type)
+	}
+
+	i_start, i_end, i_status := parseRange(req.Header.Get("Range"), i_size)
+	switch i_status {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		content.Close()
+		resp = statusResponse(req, i_status, "range "+req.Header.Get("Range")+" is outside of the data")
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", i_size))
+		return resp, nil
+	}
+	if _, err := content.Seek(i_start, io.SeekStart); err != nil {
+		content.Close()
+		return nil, fmt.Errorf("GET %s failed: %v", req.URL, err)
+	}
+	resp.StatusCode = http.StatusPartialContent
+	resp.Status = "206 Partial Content"
+	resp.Body = &readCloser{Reader: io.LimitReader(content, i_end-i_start), closers: []io.Closer{content}}
+	resp.ContentLength = i_end - i_start
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", i_start, i_end-1, i_size))
+	return resp, nil
+}
+
+// parseRange :: the byte range [start, end) asked for by a Range header and
+// the status to answer with: 206 for a single range, 416 for a range
+// outside of the content, and 200 for the whole content when there is no
+// range or it is not one that can be served
+func parseRange(s_range string, i_size int64) (int64, int64, int) {
+
+	s_spec, ok := strings.CutPrefix(strings.TrimSpace(s_range), "bytes=")
+	if !ok || strings.Contains(s_spec, ",") {
+		return 0, i_size, http.StatusOK
+	}
+	s_first, s_last, ok := strings.Cut(strings.TrimSpace(s_spec), "-")
+	if !ok {
+		return 0, i_size, http.StatusOK
+	}
+	if s_first == "" {
+		// a suffix range, the last n bytes
+		i_length, err := strconv.ParseInt(s_last, 10, 64)
+		if err != nil {
+			return 0, i_size, http.StatusOK
+		}
+		if i_length == 0 || i_size == 0 {
+			return 0, 0, http.StatusRequestedRangeNotSatisfiable
+		}
+		if i_length > i_size {
+			i_length = i_size
+		}
+		return i_size - i_length, i_size, http.StatusPartialContent
+	}
+	i_start, err := strconv.ParseInt(s_first, 10, 64)
+	if err != nil {
+		return 0, i_size, http.StatusOK
+	}
+	i_end := i_size
+	if s_last != "" {
+		i_last, err := strconv.ParseInt(s_last, 10, 64)
+		if err != nil || i_last < i_start {
+			return 0, i_size, http.StatusOK
+		}
+		if i_last+1 < i_size {
+			i_end = i_last + 1
+		}
+	}
+	if i_start >= i_size {
+		return 0, 0, http.StatusRequestedRangeNotSatisfiable
+	}
+	return i_start, i_end, http.StatusPartialContent
+}
+
+// statusResponse :: a response with a status and a plain text body, for
+// fetchers that do not speak HTTP
+func statusResponse(req *http.Request, i_status int, s_body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i_status, http.StatusText(i_status)),
+		StatusCode:    i_status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          io.NopCloser(strings.NewReader(s_body)),
+		ContentLength: int64(len(s_body)),
+		Request:       req,
+	}
+}
+
+// newRequest :: a GET request for a data URL carrying extra headers, which
+// gives fetchers of every scheme the same view of the request
+func newRequest(ctx context.Context, s_url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s_url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dataURL %q: %v", s_url, err)
+	}
+	for s_name, sa_values := range header {
+		req.Header[s_name] = sa_values
+	}
+	return req, nil
+}
@@ -0,0 +1,246 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	// aligned columns can not be told apart from other text, so the format
+	// is only used when set
+	registerFormat(formatSpec{
+		name:     formatFixedWidth,
+		text:     true,
+		lines:    true,
+		comments: true,
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newFixedRows(ctx, table, r, s_url)
+		}),
+	})
+}
+
+// i_tab_width is the tab stop used to expand tabs before slicing columns
+const i_tab_width = 8
+
+// fixedSpan is the rune range [start, end) of a fixed width column. The
+// last column of a detected layout has no end, so longer values are kept.
+type fixedSpan struct {
+	start int
+	end   int // -1 for the end of the line
+}
+
+// fixedRows reads text laid out in fixed width columns
+type fixedRows struct {
+	reader  *bufio.Reader
+	spans   []fixedSpan
+	columns []string
+	first   []string // first data row of a file without a header
+}
+
+// newFixedRows :: work out the column layout, from fixed_width_columns,
+// fixed_width_ruler, a ruler line at the start of the data or the
+// whitespace shared by the sampled lines, and consume the header row if
+// there is one
+func newFixedRows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (*fixedRows, error) {
+
+	s_header_mode, err := headerMode(table)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &fixedRows{reader: bufio.NewReaderSize(r, i_detect_bytes)}
+	switch {
+	case len(table.FixedWidthColumns) > 0:
+		for idx, ia_spec := range table.FixedWidthColumns {
+			if len(ia_spec) != 2 || ia_spec[0] < 1 || ia_spec[1] < 1 {
+				return nil, fmt.Errorf("table %s: fixed_width_columns entry %d must be a [start, length] pair counted from 1, got %v", table.Name, idx+1, ia_spec)
+			}
+			rows.spans = append(rows.spans, fixedSpan{start: int(ia_spec[0] - 1), end: int(ia_spec[0] - 1 + ia_spec[1])})
+		}
+	case table.FixedWidthRuler != nil && *table.FixedWidthRuler != "":
+		if !isRuler(*table.FixedWidthRuler) {
+			return nil, fmt.Errorf("table %s: fixed_width_ruler must be made of runs of - or = separated by spaces", table.Name)
+		}
+		rows.spans = rulerSpans(*table.FixedWidthRuler)
+	default:
+		// only look at complete lines at the start of the data
+		sample, _ := rows.reader.Peek(i_detect_bytes)
+		if i_newline := bytes.LastIndexByte(sample, '\n'); i_newline >= 0 && len(sample) == i_detect_bytes {
+			sample = sample[:i_newline]
+		}
+		var sa_lines []string
+		for _, s_line := range strings.Split(string(sample), "\n") {
+			if s_line = expandTabs(s_line); strings.TrimSpace(s_line) != "" {
+				sa_lines = append(sa_lines, s_line)
+			}
+		}
+		rows.spans = detectSpans(sa_lines)
+	}
+	if len(rows.spans) == 0 {
+		return nil, fmt.Errorf("could not detect fixed width columns for %s, set fixed_width_columns or fixed_width_ruler", s_url)
+	}
+
+	sa_first, err := rows.record()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no rows read from %s", s_url)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s_url, err)
+	}
+	if hasHeader(sa_first, s_header_mode) {
+		if ok, s_message := validHeader(ctx, sa_first); !ok {
+			return nil, fmt.Errorf("%s: %s", s_url, s_message)
+		}
+		rows.columns = sa_first
+	} else {
+		rows.columns = generatedColumns(len(sa_first))
+		rows.first = sa_first
+	}
+	return rows, nil
+}
+
+// detectSpans :: the column layout of sampled lines. A ruler line near the
+// top, such as the dashes under a report heading, gives the columns.
+// Otherwise columns are separated by the positions that are blank in every
+// line.
+func detectSpans(sa_lines []string) []fixedSpan {
+
+	for idx, s_line := range sa_lines {
+		if idx > 2 {
+			break
+		}
+		if isRuler(s_line) {
+			return rulerSpans(s_line)
+		}
+	}
+
+	var blank []bool
+	for _, s_line := range sa_lines {
+		if isRuler(s_line) {
+			continue
+		}
+		for idx, r := range []rune(s_line) {
+			for len(blank) <= idx {
+				blank = append(blank, true)
+			}
+			if r != ' ' {
+				blank[idx] = false
+			}
+		}
+	}
+	return blankSpans(blank)
+}
+
+// rulerSpans :: one column for each run of - or = in a ruler line
+func rulerSpans(s_ruler string) []fixedSpan {
+	var blank []bool
+	for _, r := range expandTabs(s_ruler) {
+		blank = append(blank, r == ' ')
+	}
+	return blankSpans(blank)
+}
+
+// blankSpans :: the runs of non blank positions, the last one left open
+func blankSpans(blank []bool) []fixedSpan {
+	var spans []fixedSpan
+	for idx := 0; idx < len(blank); idx++ {
+		if blank[idx] {
+			continue
+		}
+		span := fixedSpan{start: idx}
+		for idx < len(blank) && !blank[idx] {
+			idx++
+		}
+		span.end = idx
+		spans = append(spans, span)
+	}
+	if len(spans) > 0 {
+		spans[len(spans)-1].end = -1
+	}
+	return spans
+}
+
+// isRuler :: whether a line only holds runs of - or = between spaces, with
+// + allowed where the runs meet
+func isRuler(s_line string) bool {
+	s_trimmed := strings.TrimSpace(s_line)
+	if s_trimmed == "" || !strings.ContainsAny(s_trimmed, "-=") {
+		return false
+	}
+	return strings.Trim(s_trimmed, "-=+ \t") == ""
+}
+
+// expandTabs :: replace tabs with spaces up to the next tab stop
+func expandTabs(s_line string) string {
+	s_line = strings.TrimRight(s_line, "\r")
+	if !strings.Contains(s_line, "\t") {
+		return s_line
+	}
+	var sb strings.Builder
+	i_col := 0
+	for _, r := range s_line {
+		if r == '\t' {
+			i_spaces := i_tab_width - i_col%i_tab_width
+			sb.WriteString(strings.Repeat(" ", i_spaces))
+			i_col += i_spaces
+			continue
+		}
+		sb.WriteRune(r)
+		i_col++
+	}
+	return sb.String()
+}
+
+// record :: the fields of the next line with data, skipping blank lines and
+// ruler lines
+func (rows *fixedRows) record() ([]string, error) {
+	for {
+		s_line, err := rows.reader.ReadString('\n')
+		if s_line == "" && err != nil {
+			return nil, err
+		}
+		s_line = expandTabs(strings.TrimRight(s_line, "\n"))
+		if strings.TrimSpace(s_line) == "" || isRuler(s_line) {
+			continue
+		}
+
+		ra_line := []rune(s_line)
+		sa_fields := make([]string, len(rows.spans))
+		for idx, span := range rows.spans {
+			i_end := span.end
+			if i_end < 0 || i_end > len(ra_line) {
+				i_end = len(ra_line)
+			}
+			if span.start < i_end {
+				sa_fields[idx] = strings.TrimSpace(string(ra_line[span.start:i_end]))
+			}
+		}
+		return sa_fields, nil
+	}
+}
+
+func (rows *fixedRows) Columns() []string {
+	return rows.columns
+}
+
+// Next maps the fields of the next line onto the column names
+func (rows *fixedRows) Next() (map[string]interface{}, error) {
+	sa_record := rows.first
+	rows.first = nil
+	if sa_record == nil {
+		var err error
+		if sa_record, err = rows.record(); err != nil {
+			return nil, err
+		}
+	}
+
+	sm_row := map[string]interface{}{}
+	for idx, s_value := range sa_record {
+		sm_row[rows.columns[idx]] = s_value
+	}
+	return sm_row, nil
+}
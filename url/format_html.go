@@ -0,0 +1,248 @@
+package url
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// i_html_max_span caps colspan and rowspan, which some pages set to huge
+// values to fill the width of the table
+const i_html_max_span = 1000
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatHTML,
+		mimeTypes:  []string{"text/html", "application/xhtml+xml"},
+		extensions: []string{".html", ".htm"},
+		text:       true,
+		sniff: func(table tableConfig, head []byte) bool {
+			return isHTML(head)
+		},
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newHTMLRows(table, r, s_url)
+		}),
+	})
+}
+
+// isHTML :: whether the start of the data is a web page rather than XML
+func isHTML(head []byte) bool {
+	s_head := strings.ToLower(string(sniffText(head)))
+	if !strings.HasPrefix(s_head, "<") {
+		return false
+	}
+	for _, s_tag := range []string{"<!doctype html", "<html", "<head", "<body", "<table"} {
+		if strings.Contains(s_head, s_tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlRows reads the rows of a table on a web page
+type htmlRows struct {
+	columns []string
+	cells   [][]string
+	next    int
+}
+
+// newHTMLRows :: find the table on the page, the first one matching
+// html_selector or the html_table_index'th, and lay out its cells. Header
+// rows are the leading rows of <th> cells or the rows in <thead>.
+func newHTMLRows(table tableConfig, r io.Reader, s_url string) (*htmlRows, error) {
+
+	s_header_mode, err := headerMode(table)
+	if err != nil {
+		return nil, err
+	}
+	s_selector := "table"
+	if table.HTMLSelector != nil && *table.HTMLSelector != "" {
+		s_selector = *table.HTMLSelector
+	}
+	selector, err := cascadia.Compile(s_selector)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: invalid html_selector %q: %v", table.Name, s_selector, err)
+	}
+	i_index := int64(1)
+	if table.HTMLTableIndex != nil {
+		i_index = *table.HTMLTableIndex
+	}
+	if i_index < 1 {
+		return nil, fmt.Errorf("table %s: html_table_index must be 1 or more, got %d", table.Name, i_index)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s_url, err)
+	}
+	// a selector may pick an element holding the table rather than the table
+	var tables []*goquery.Selection
+	doc.FindMatcher(selector).Each(func(_ int, match *goquery.Selection) {
+		if goquery.NodeName(match) != "table" {
+			match = match.Find("table").First()
+		}
+		if match.Length() > 0 {
+			tables = append(tables, match)
+		}
+	})
+	if int64(len(tables)) < i_index {
+		return nil, fmt.Errorf("%s: found %d tables matching %q, html_table_index is %d", s_url, len(tables), s_selector, i_index)
+	}
+	grid, i_header_rows := htmlGrid(tables[i_index-1])
+
+	switch s_header_mode {
+	case "true":
+		if i_header_rows == 0 {
+			i_header_rows = 1
+		}
+	case "false":
+		i_header_rows = 0
+	}
+	if i_header_rows > len(grid) {
+		i_header_rows = len(grid)
+	}
+
+	rows := &htmlRows{}
+	i_width := 0
+	for _, row := range grid {
+		if len(row) > i_width {
+			i_width = len(row)
+		}
+	}
+	if i_header_rows > 0 {
+		rows.columns = htmlColumns(grid[:i_header_rows], i_width)
+	} else {
+		rows.columns = generatedColumns(i_width)
+	}
+	for _, row := range grid[i_header_rows:] {
+		if strings.Join(row, "") != "" {
+			rows.cells = append(rows.cells, row)
+		}
+	}
+	return rows, nil
+}
+
+// htmlGrid :: the text of the cells of a table laid out on a grid, with
+// cells spanning several columns or rows repeated in each, and the number
+// of leading header rows. Rows of nested tables are left out.
+func htmlGrid(table *goquery.Selection) ([][]string, int) {
+
+	var grid [][]string
+	filled := map[[2]int]bool{}
+	i_header_rows := 0
+	b_header := true
+
+	// the rows of this table in document order, not those of nested tables
+	own := table.ChildrenFiltered("tr").AddSelection(table.ChildrenFiltered("thead, tbody, tfoot").ChildrenFiltered("tr"))
+	rows := table.Find("tr").FilterSelection(own)
+
+	rows.Each(func(i_row int, tr *goquery.Selection) {
+		for len(grid) <= i_row {
+			grid = append(grid, nil)
+		}
+		cells := tr.ChildrenFiltered("th, td")
+		b_th := cells.Length() > 0 && cells.Length() == tr.ChildrenFiltered("th").Length()
+		b_thead := goquery.NodeName(tr.Parent()) == "thead"
+		if b_header && (b_th || b_thead) {
+			i_header_rows++
+		} else {
+			b_header = false
+		}
+
+		i_col := 0
+		cells.Each(func(_ int, cell *goquery.Selection) {
+			for filled[[2]int{i_row, i_col}] {
+				i_col++
+			}
+			s_text := strings.Join(strings.Fields(cell.Text()), " ")
+			i_colspan := htmlSpan(cell, "colspan")
+			i_rowspan := htmlSpan(cell, "rowspan")
+			for r := i_row; r < i_row+i_rowspan; r++ {
+				for len(grid) <= r {
+					grid = append(grid, nil)
+				}
+				for c := i_col; c < i_col+i_colspan; c++ {
+					for len(grid[r]) <= c {
+						grid[r] = append(grid[r], "")
+					}
+					grid[r][c] = s_text
+					filled[[2]int{r, c}] = true
+				}
+			}
+			i_col += i_colspan
+		})
+	})
+
+	// rowspan may reach past the last row
+	if i_rows := rows.Length(); len(grid) > i_rows {
+		grid = grid[:i_rows]
+	}
+	return grid, i_header_rows
+}
+
+// htmlSpan :: the colspan or rowspan of a cell, at least 1
+func htmlSpan(cell *goquery.Selection, s_attr string) int {
+	i_span, err := strconv.Atoi(strings.TrimSpace(cell.AttrOr(s_attr, "1")))
+	if err != nil || i_span < 1 {
+		return 1
+	}
+	if i_span > i_html_max_span {
+		return i_html_max_span
+	}
+	return i_span
+}
+
+// htmlColumns :: column names from the header rows. Stacked headers are
+// joined, so a "Population" cell spanning "2010" and "2020" gives the
+// columns "Population 2010" and "Population 2020". Empty names are
+// generated and duplicates numbered, as pages often leave corners blank.
+func htmlColumns(header [][]string, i_width int) []string {
+
+	sa_columns := generatedColumns(i_width)
+	seen := map[string]int{}
+	for i_col := range sa_columns {
+		var sa_parts []string
+		for _, row := range header {
+			if i_col >= len(row) || row[i_col] == "" {
+				continue
+			}
+			// a cell spanning rows is repeated in each, use it once
+			if len(sa_parts) > 0 && sa_parts[len(sa_parts)-1] == row[i_col] {
+				continue
+			}
+			sa_parts = append(sa_parts, row[i_col])
+		}
+		if len(sa_parts) > 0 {
+			sa_columns[i_col] = strings.Join(sa_parts, " ")
+		}
+		seen[sa_columns[i_col]]++
+		if i_count := seen[sa_columns[i_col]]; i_count > 1 {
+			sa_columns[i_col] = fmt.Sprintf("%s_%d", sa_columns[i_col], i_count)
+		}
+	}
+	return sa_columns
+}
+
+func (rows *htmlRows) Columns() []string {
+	return rows.columns
+}
+
+// Next maps the next row onto the column names
+func (rows *htmlRows) Next() (map[string]interface{}, error) {
+	if rows.next >= len(rows.cells) {
+		return nil, io.EOF
+	}
+	sm_row := map[string]interface{}{}
+	for idx, s_value := range rows.cells[rows.next] {
+		if idx < len(rows.columns) {
+			sm_row[rows.columns[idx]] = s_value
+		}
+	}
+	rows.next++
+	return sm_row, nil
+}
@@ -0,0 +1,233 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatJSON,
+		mimeTypes:  []string{"application/json", "text/json"},
+		extensions: []string{".json", ".geojson"},
+		text:       true,
+		// a document holding an object is only read with json_path
+		sniff: func(table tableConfig, head []byte) bool {
+			head = sniffText(head)
+			return bytes.HasPrefix(head, []byte("[")) || bytes.HasPrefix(head, []byte("{")) && stringValue(table.JSONPath) != ""
+		},
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newJSONRows(table, r, s_url)
+		}),
+	})
+	registerFormat(formatSpec{
+		name:       formatJSONL,
+		aliases:    []string{"ndjson"},
+		mimeTypes:  []string{"application/x-ndjson", "application/jsonl", "application/x-jsonlines", "application/jsonlines"},
+		extensions: []string{".jsonl", ".ndjson"},
+		text:       true,
+		lines:      true,
+		sniff: func(table tableConfig, head []byte) bool {
+			return bytes.HasPrefix(sniffText(head), []byte("{")) && stringValue(table.JSONPath) == ""
+		},
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newJSONLRows(r, s_url)
+		}),
+	})
+}
+
+// jsonRows reads rows from a JSON array of objects, found at json_path
+type jsonRows struct {
+	decoder *json.Decoder
+	url     string
+	columns []string
+	seen    map[string]bool
+	done    bool
+}
+
+// newJSONRows :: position a JSON decoder on the first element of the array
+// holding the rows, the top level value unless json_path is set
+func newJSONRows(table tableConfig, r io.Reader, s_url string) (*jsonRows, error) {
+
+	sa_path, err := parseJSONPath(stringValue(table.JSONPath))
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+
+	rows := &jsonRows{decoder: json.NewDecoder(r), url: s_url, seen: map[string]bool{}}
+	rows.decoder.UseNumber()
+
+	for _, s_step := range sa_path {
+		if err := rows.enter(s_step); err != nil {
+			return nil, fmt.Errorf("%s: json_path %s: %v", s_url, stringValue(table.JSONPath), err)
+		}
+	}
+
+	token, err := rows.decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s_url, err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("%s: expected an array of rows, set json_path to the array inside the document", s_url)
+	}
+	return rows, nil
+}
+
+// enter :: move the decoder into the value of an object key, or the element
+// of an array when the step is an index
+func (rows *jsonRows) enter(s_step string) error {
+
+	token, err := rows.decoder.Token()
+	if err != nil {
+		return err
+	}
+	delim, _ := token.(json.Delim)
+
+	if i_index, err := strconv.Atoi(s_step); err == nil {
+		if delim != '[' {
+			return fmt.Errorf("[%d] is not an array", i_index)
+		}
+		for i := 0; i < i_index; i++ {
+			if !rows.decoder.More() {
+				return fmt.Errorf("array has no element %d", i_index)
+			}
+			var skip json.RawMessage
+			if err := rows.decoder.Decode(&skip); err != nil {
+				return err
+			}
+		}
+		if !rows.decoder.More() {
+			return fmt.Errorf("array has no element %d", i_index)
+		}
+		return nil
+	}
+
+	if delim != '{' {
+		return fmt.Errorf("%s is not an object", s_step)
+	}
+	for rows.decoder.More() {
+		token, err := rows.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token == s_step {
+			return nil
+		}
+		var skip json.RawMessage
+		if err := rows.decoder.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("key %s not found", s_step)
+}
+
+func (rows *jsonRows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *jsonRows) Next() (map[string]interface{}, error) {
+	if rows.done || !rows.decoder.More() {
+		rows.done = true
+		return nil, io.EOF
+	}
+	var value interface{}
+	if err := rows.decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%s: %v", rows.url, err)
+	}
+	return rows.row(value), nil
+}
+
+// row :: an object becomes a row with its keys as columns, any other value
+// a row with a single value column
+func (rows *jsonRows) row(value interface{}) map[string]interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{"value": value}
+	}
+	var sa_new []string
+	for s_key, v := range object {
+		if !rows.seen[s_key] {
+			rows.seen[s_key] = true
+			sa_new = append(sa_new, s_key)
+		}
+		object[s_key] = jsonValue(v)
+	}
+	// object keys come out of the decoder unordered, add new columns sorted
+	// so the schema does not change from one load to the next
+	sort.Strings(sa_new)
+	rows.columns = append(rows.columns, sa_new...)
+	return object
+}
+
+// jsonLRows reads one JSON value per line
+type jsonLRows struct {
+	jsonRows
+}
+
+func newJSONLRows(r io.Reader, s_url string) (*jsonLRows, error) {
+	rows := &jsonLRows{jsonRows{decoder: json.NewDecoder(r), url: s_url, seen: map[string]bool{}}}
+	rows.decoder.UseNumber()
+	return rows, nil
+}
+
+func (rows *jsonLRows) Next() (map[string]interface{}, error) {
+	var value interface{}
+	if err := rows.decoder.Decode(&value); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s: %v", rows.url, err)
+	}
+	return rows.row(value), nil
+}
+
+// jsonValue :: numbers are decoded as json.Number to keep large integers
+// exact, convert them to int64 or float64. Nested values are left as is and
+// become JSON columns.
+func jsonValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i_value, err := number.Int64(); err == nil {
+		return i_value
+	}
+	if f_value, err := number.Float64(); err == nil {
+		return f_value
+	}
+	return number.String()
+}
+
+// parseJSONPath :: split a path such as "$.data.items" or "results[0].rows"
+// into object keys and array indexes
+func parseJSONPath(s_path string) ([]string, error) {
+
+	s_path = strings.TrimPrefix(strings.TrimSpace(s_path), "$")
+	var sa_steps []string
+	for _, s_part := range strings.Split(s_path, ".") {
+		for s_part != "" {
+			i_open := strings.Index(s_part, "[")
+			if i_open < 0 {
+				sa_steps = append(sa_steps, s_part)
+				break
+			}
+			if i_open > 0 {
+				sa_steps = append(sa_steps, s_part[:i_open])
+			}
+			i_close := strings.Index(s_part, "]")
+			if i_close < i_open {
+				return nil, fmt.Errorf("invalid json_path %q", s_path)
+			}
+			s_index := strings.Trim(s_part[i_open+1:i_close], `'"`)
+			sa_steps = append(sa_steps, s_index)
+			s_part = s_part[i_close+1:]
+		}
+	}
+	return sa_steps, nil
+}
@@ -0,0 +1,259 @@
+package url
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XML namespaces of OpenDocument content
+const (
+	odsOfficeNS = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	odsTableNS  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odsTextNS   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+)
+
+// i_ods_max_columns caps repeated cells, which LibreOffice uses to style
+// whole rows out to the last column
+const i_ods_max_columns = 16384
+
+// readODS :: the sheet names of an OpenDocument spreadsheet, and the cells
+// of one sheet, read from the content.xml member of the document
+func readODS(buff []byte, s_url string, s_sheet string) ([]string, [][]interface{}, error) {
+
+	zr, err := zip.NewReader(bytes.NewReader(buff), int64(len(buff)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading ods document %s: %v", s_url, err)
+	}
+	var content io.ReadCloser
+	for _, member := range zr.File {
+		if member.Name == "content.xml" {
+			content, err = member.Open()
+			break
+		}
+	}
+	if content == nil || err != nil {
+		return nil, nil, fmt.Errorf("reading ods document %s: no content.xml found", s_url)
+	}
+	defer content.Close()
+
+	decoder := xml.NewDecoder(content)
+	var sa_sheets []string
+	var cells [][]interface{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return sa_sheets, cells, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading ods document %s: %v", s_url, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Space != odsTableNS || start.Name.Local != "table" {
+			continue
+		}
+		s_name := odsAttr(start, odsTableNS, "name")
+		sa_sheets = append(sa_sheets, s_name)
+		if s_sheet == "" || s_name != s_sheet {
+			if err := decoder.Skip(); err != nil {
+				return nil, nil, fmt.Errorf("reading ods document %s: %v", s_url, err)
+			}
+			continue
+		}
+		if cells, err = odsTable(decoder); err != nil {
+			return nil, nil, fmt.Errorf("reading sheet %s of %s: %v", s_sheet, s_url, err)
+		}
+	}
+}
+
+// odsTable :: read the rows of a table:table element. Empty rows and cells
+// that are repeated are only kept when data follows them.
+func odsTable(decoder *xml.Decoder) ([][]interface{}, error) {
+
+	var cells [][]interface{}
+	i_empty_rows := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			if t.Name.Space == odsTableNS && t.Name.Local == "table" {
+				return cells, nil
+			}
+		case xml.StartElement:
+			// rows may be nested in header rows or row groups
+			if t.Name.Space != odsTableNS || t.Name.Local != "table-row" {
+				continue
+			}
+			row, err := odsRow(decoder)
+			if err != nil {
+				return nil, err
+			}
+			i_repeat := odsRepeat(t, "number-rows-repeated")
+			if len(row) == 0 {
+				i_empty_rows += i_repeat
+				continue
+			}
+			for ; i_empty_rows > 0; i_empty_rows-- {
+				cells = append(cells, nil)
+			}
+			for i := 0; i < i_repeat; i++ {
+				cells = append(cells, row)
+			}
+		}
+	}
+}
+
+// odsRow :: read the cells of a table:table-row element, without trailing
+// empty cells
+func odsRow(decoder *xml.Decoder) ([]interface{}, error) {
+
+	var row []interface{}
+	i_empty_cells := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			if t.Name.Space == odsTableNS && t.Name.Local == "table-row" {
+				return row, nil
+			}
+		case xml.StartElement:
+			if t.Name.Space != odsTableNS || (t.Name.Local != "table-cell" && t.Name.Local != "covered-table-cell") {
+				continue
+			}
+			value, err := odsCell(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			i_repeat := odsRepeat(t, "number-columns-repeated")
+			if value == nil {
+				i_empty_cells += i_repeat
+				continue
+			}
+			for ; i_empty_cells > 0 && len(row) < i_ods_max_columns; i_empty_cells-- {
+				row = append(row, nil)
+			}
+			i_empty_cells = 0
+			for i := 0; i < i_repeat && len(row) < i_ods_max_columns; i++ {
+				row = append(row, value)
+			}
+		}
+	}
+}
+
+// odsCell :: the native value of a cell, from its office:value-type and the
+// matching value attribute, falling back on the text of the cell
+func odsCell(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+
+	s_text, err := odsText(decoder, start.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch odsAttr(start, odsOfficeNS, "value-type") {
+	case "float", "percentage", "currency":
+		if f_value, err := strconv.ParseFloat(odsAttr(start, odsOfficeNS, "value"), 64); err == nil {
+			return sheetNumber(f_value), nil
+		}
+	case "date":
+		s_date := odsAttr(start, odsOfficeNS, "date-value")
+		for _, s_layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(s_layout, s_date); err == nil {
+				return t, nil
+			}
+		}
+	case "boolean":
+		if b_value, err := strconv.ParseBool(odsAttr(start, odsOfficeNS, "boolean-value")); err == nil {
+			return b_value, nil
+		}
+	}
+	if s_text == "" {
+		return nil, nil
+	}
+	return s_text, nil
+}
+
+// odsText :: the text content of a cell, with paragraphs on separate lines
+// and the space, tab and line break elements expanded
+func odsText(decoder *xml.Decoder, name xml.Name) (string, error) {
+
+	var sb strings.Builder
+	i_paragraphs := 0
+	i_open := 0 // text outside paragraphs is layout whitespace
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			if i_open > 0 {
+				sb.Write(t)
+			}
+		case xml.StartElement:
+			if t.Name.Space == odsOfficeNS && t.Name.Local == "annotation" {
+				// comments are not part of the value
+				if err := decoder.Skip(); err != nil {
+					return "", err
+				}
+				continue
+			}
+			if t.Name.Space != odsTextNS {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				if i_paragraphs > 0 {
+					sb.WriteString("\n")
+				}
+				i_paragraphs++
+				i_open++
+			case "s":
+				i_count, err := strconv.Atoi(odsAttr(t, odsTextNS, "c"))
+				if err != nil || i_count < 1 {
+					i_count = 1
+				}
+				sb.WriteString(strings.Repeat(" ", i_count))
+			case "tab":
+				sb.WriteString("\t")
+			case "line-break":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			if t.Name == name {
+				return sb.String(), nil
+			}
+			if t.Name.Space == odsTextNS && t.Name.Local == "p" {
+				i_open--
+			}
+		}
+	}
+}
+
+func odsAttr(start xml.StartElement, s_space string, s_local string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == s_space && attr.Name.Local == s_local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat :: the repeat count of a row or cell, at least 1
+func odsRepeat(start xml.StartElement, s_local string) int {
+	i_repeat, err := strconv.Atoi(odsAttr(start, odsTableNS, s_local))
+	if err != nil || i_repeat < 1 {
+		return 1
+	}
+	return i_repeat
+}
@@ -0,0 +1,602 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/types"
+)
+
+const (
+	i_parquet_tail  int64 = 64 * 1024   // bytes fetched from the end of the file for the footer
+	i_parquet_block int64 = 1024 * 1024 // smallest range fetched when reading column chunks
+	i_parquet_batch int64 = 1000        // rows read from each column at a time
+)
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatParquet,
+		mimeTypes:  []string{"application/vnd.apache.parquet", "application/x-parquet"},
+		extensions: []string{".parquet", ".pq"},
+		sniff: func(table tableConfig, head []byte) bool {
+			return bytes.HasPrefix(head, []byte("PAR1"))
+		},
+		decoder: parquetDecoder{},
+	})
+}
+
+// parquetDecoder reads parquet files by range requests rather than as a stream
+type parquetDecoder struct{}
+
+func (parquetDecoder) Rows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+	return nil, fmt.Errorf("parquet data from %s must be read by range requests", s_url)
+}
+
+func (parquetDecoder) Schema(ctx context.Context, table tableConfig) ([]string, map[string]string, error) {
+	return parquetSchema(ctx, table)
+}
+
+func (parquetDecoder) List(ctx context.Context, d *plugin.QueryData, table tableConfig) error {
+	return listParquet(ctx, d, table)
+}
+
+// rangeFile gives parquet-go random access to a data URL, fetching the
+// bytes it reads with HTTP Range requests. Each column reader opens its own
+// rangeFile, so only the column chunks of the queried columns are fetched.
+type rangeFile struct {
+	ctx    context.Context
+	table  tableConfig
+	url    string
+	size   int64
+	offset int64
+
+	block      []byte
+	blockStart int64
+}
+
+// newRangeFile :: fetch the end of the file, which holds the footer, and
+// learn the file size from the Content-Range of the response
+func newRangeFile(ctx context.Context, table tableConfig) (*rangeFile, error) {
+
+	s_url := stringValue(table.DataURL)
+	if s_url == "" {
+		return nil, fmt.Errorf("table %s has no dataURL", table.Name)
+	}
+	file := &rangeFile{ctx: ctx, table: table, url: s_url}
+
+	buff, s_range, err := file.get(fmt.Sprintf("bytes=-%d", i_parquet_tail))
+	if err != nil {
+		return nil, err
+	}
+	// Content-Range is "bytes first-last/size"
+	i_slash := strings.LastIndex(s_range, "/")
+	i_size, err := strconv.ParseInt(s_range[i_slash+1:], 10, 64)
+	if i_slash < 0 || err != nil {
+		return nil, fmt.Errorf("GET %s returned an invalid Content-Range %q", s_url, s_range)
+	}
+	file.size = i_size
+	file.block = buff
+	file.blockStart = i_size - int64(len(buff))
+	return file, nil
+}
+
+// get :: send a Range request and return the partial content along with
+// its Content-Range header
+func (f *rangeFile) get(s_range string) ([]byte, string, error) {
+
+	settings, err := tableRetrySettings(f.table)
+	if err != nil {
+		return nil, "", fmt.Errorf("table %s: %v", f.table.Name, err)
+	}
+	timeout, err := durationOption(f.table.Timeout, "timeout", 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("table %s: %v", f.table.Name, err)
+	}
+	readTimeout, err := durationOption(f.table.ReadTimeout, "read_timeout", defaultReadTimeout)
+	if err != nil {
+		return nil, "", fmt.Errorf("table %s: %v", f.table.Name, err)
+	}
+
+	// the timeout applies to each range request, not the whole file
+	ctx, cancel := context.WithCancel(f.ctx)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(f.ctx, timeout)
+	}
+
+	// ask for the bytes as stored, a transparently decoded body would not
+	// match the requested range
+	header := http.Header{}
+	header.Set("Range", s_range)
+	header.Set("Accept-Encoding", "identity")
+
+	resp, err := getWithRetries(ctx, f.table, f.url, header, settings)
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, "", fmt.Errorf("GET %s did not complete within timeout of %s", f.url, timeout)
+		}
+		return nil, "", err
+	}
+	body := newTimeoutBody(resp.Body, f.url, readTimeout, cancel)
+	defer body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, "", fmt.Errorf("%s does not support HTTP range requests, which are needed to read parquet", f.url)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, "", &fetchError{
+			URL:        f.url,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Snippet:    bodySnippet(body),
+		}
+	}
+
+	buff, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("GET %s: %v", f.url, err)
+	}
+	return buff, resp.Header.Get("Content-Range"), nil
+}
+
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek to negative offset %d in %s", offset, f.url)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Read serves the read from the last fetched block when it holds the
+// offset, and otherwise fetches a new block of at least len(p) bytes
+func (f *rangeFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.offset < f.blockStart || f.offset >= f.blockStart+int64(len(f.block)) {
+		i_length := int64(len(p))
+		if i_length < i_parquet_block {
+			i_length = i_parquet_block
+		}
+		i_end := f.offset + i_length
+		if i_end > f.size {
+			i_end = f.size
+		}
+		buff, _, err := f.get(fmt.Sprintf("bytes=%d-%d", f.offset, i_end-1))
+		if err != nil {
+			return 0, err
+		}
+		if len(buff) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		f.block = buff
+		f.blockStart = f.offset
+	}
+	n := copy(p, f.block[f.offset-f.blockStart:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *rangeFile) Write(p []byte) (int, error) {
+	return 0, errors.New("parquet data URLs are read only")
+}
+
+func (f *rangeFile) Close() error {
+	f.block = nil
+	return nil
+}
+
+// Open is called for each column reader, which share the file size but
+// keep their own offset and block
+func (f *rangeFile) Open(name string) (source.ParquetFile, error) {
+	if name != "" {
+		return nil, fmt.Errorf("%s: column chunks stored in other files are not supported", f.url)
+	}
+	return &rangeFile{ctx: f.ctx, table: f.table, url: f.url, size: f.size}, nil
+}
+
+func (f *rangeFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("parquet data URLs are read only")
+}
+
+// parquetColumn is a leaf column of a parquet file
+type parquetColumn struct {
+	name     string
+	path     string // internal path, for ReadColumnByPath
+	element  *parquet.SchemaElement
+	repeated bool
+}
+
+// openParquet :: read the footer of a parquet data URL and list its columns
+func openParquet(ctx context.Context, table tableConfig) (*reader.ParquetReader, []parquetColumn, error) {
+
+	// column chunks are fetched by offset, which needs the file as stored
+	if table.member != "" {
+		return nil, nil, fmt.Errorf("parquet files cannot be read from inside an archive")
+	}
+
+	file, err := newRangeFile(ctx, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading parquet footer of %s: %v", file.url, err)
+	}
+
+	handler := pr.SchemaHandler
+	var columns []parquetColumn
+	for _, s_path := range handler.ValueColumns {
+		sa_in := common.StrToPath(s_path)
+		sa_ex := common.StrToPath(handler.InPathToExPath[s_path])
+		i_max_rl, err := handler.MaxRepetitionLevel(sa_in)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading parquet schema of %s: %v", file.url, err)
+		}
+
+		// name nested columns by their path, leaving out the groups that
+		// only wrap the elements of a list or map
+		var sa_name []string
+		for idx := 1; idx < len(sa_ex); idx++ {
+			sa_name = append(sa_name, sa_ex[idx])
+			element := handler.SchemaElements[handler.MapIndex[common.PathToStr(sa_in[:idx+1])]]
+			if isParquetList(element) {
+				// tags.list.element is named tags, items.list.element.id items.id
+				if len(sa_ex)-idx <= 3 {
+					break
+				}
+				idx += 2
+			} else if isParquetMap(element) {
+				// attrs.key_value.key is named attrs.key
+				idx++
+			}
+		}
+
+		columns = append(columns, parquetColumn{
+			name:     strings.Join(sa_name, "."),
+			path:     s_path,
+			element:  handler.SchemaElements[handler.MapIndex[s_path]],
+			repeated: i_max_rl > 0,
+		})
+	}
+	return pr, columns, nil
+}
+
+// parquetSchema :: the columns of a parquet data URL and their types, taken
+// from the footer without reading any rows
+func parquetSchema(ctx context.Context, table tableConfig) ([]string, map[string]string, error) {
+
+	pr, columns, err := openParquet(ctx, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pr.PFile.Close()
+
+	var sa_columns []string
+	sa_column_map := map[string]string{}
+	for _, column := range columns {
+		if _, ok := sa_column_map[column.name]; ok {
+			return nil, nil, fmt.Errorf("duplicate parquet column %s in %s", column.name, stringValue(table.DataURL))
+		}
+		sa_columns = append(sa_columns, column.name)
+		sa_column_map[column.name] = parquetType(column)
+	}
+	if len(sa_columns) == 0 {
+		return nil, nil, fmt.Errorf("no columns found in %s", stringValue(table.DataURL))
+	}
+	return sa_columns, sa_column_map, nil
+}
+
+// listParquet :: stream the rows of a parquet data URL, reading only the
+// columns used by the query, a batch of rows at a time
+func listParquet(ctx context.Context, d *plugin.QueryData, table tableConfig) error {
+
+	invalid, err := newInvalidBytes(table)
+	if err != nil {
+		return err
+	}
+	pr, columns, err := openParquet(ctx, table)
+	if err != nil {
+		return err
+	}
+	defer pr.PFile.Close()
+	defer invalid.log(ctx, table)
+
+	sm_wanted := map[string]bool{}
+	for _, s_column := range d.QueryContext.Columns {
+		sm_wanted[s_column] = true
+	}
+	var queried []parquetColumn
+	b_data_column := false
+	for _, column := range columns {
+		if column.name == s_invalid_bytes_column {
+			b_data_column = true
+		}
+		if len(sm_wanted) == 0 || sm_wanted[column.name] {
+			queried = append(queried, column)
+		}
+	}
+	plugin.Logger(ctx).Debug("listParquet", "table", table.Name, "rows", pr.GetNumRows(), "columns", len(queried))
+
+	i_total := pr.GetNumRows()
+	for i_read := int64(0); i_read < i_total; {
+		i_batch := i_parquet_batch
+		if i_total-i_read < i_batch {
+			i_batch = i_total - i_read
+		}
+
+		rows := make([]map[string]interface{}, i_batch)
+		for idx := range rows {
+			rows[idx] = map[string]interface{}{}
+		}
+		for _, column := range queried {
+			values, rls, _, err := pr.ReadColumnByPath(column.path, i_batch)
+			if err != nil {
+				return fmt.Errorf("reading column %s of %s: %v", column.name, stringValue(table.DataURL), err)
+			}
+			if !column.repeated {
+				for idx, value := range values {
+					if int64(idx) < i_batch {
+						if rows[idx][column.name], err = parquetText(column.element, parquetValue(column.element, value), invalid); err != nil {
+							return fmt.Errorf("column %s of %s: %v", column.name, stringValue(table.DataURL), err)
+						}
+					}
+				}
+				continue
+			}
+			// a repetition level of 0 starts the values of the next row
+			i_row := int64(-1)
+			for idx, value := range values {
+				if rls[idx] == 0 {
+					i_row++
+				}
+				if i_row < 0 || i_row >= i_batch {
+					continue
+				}
+				if value == nil && rls[idx] == 0 {
+					continue
+				}
+				element, err := parquetText(column.element, parquetValue(column.element, value), invalid)
+				if err != nil {
+					return fmt.Errorf("column %s of %s: %v", column.name, stringValue(table.DataURL), err)
+				}
+				list, _ := rows[i_row][column.name].([]interface{})
+				rows[i_row][column.name] = append(list, element)
+			}
+		}
+
+		for _, sm_row := range rows {
+			if !b_data_column {
+				sm_row[s_invalid_bytes_column] = invalid.Count()
+			}
+			d.StreamListItem(ctx, sm_row)
+			// stop reading once the query LIMIT has been satisfied
+			if d.RowsRemaining(ctx) == 0 {
+				return nil
+			}
+		}
+		i_read += i_batch
+	}
+	return nil
+}
+
+// parquetLogicalType :: the logical type annotation of a column, empty
+// rather than nil when there is none so its IsSet methods can be called
+func parquetLogicalType(element *parquet.SchemaElement) *parquet.LogicalType {
+	if element.IsSetLogicalType() {
+		return element.GetLogicalType()
+	}
+	return &parquet.LogicalType{}
+}
+
+func isParquetList(element *parquet.SchemaElement) bool {
+	return element.IsSetConvertedType() && element.GetConvertedType() == parquet.ConvertedType_LIST ||
+		parquetLogicalType(element).IsSetLIST()
+}
+
+func isParquetMap(element *parquet.SchemaElement) bool {
+	converted := element.GetConvertedType()
+	return element.IsSetConvertedType() && (converted == parquet.ConvertedType_MAP || converted == parquet.ConvertedType_MAP_KEY_VALUE) ||
+		parquetLogicalType(element).IsSetMAP()
+}
+
+// parquetType :: the column type of a parquet column, as returned by
+// inferTypes. Repeated columns hold a list of values and are JSON.
+func parquetType(column parquetColumn) string {
+
+	if column.repeated {
+		return "JSON"
+	}
+	element := column.element
+	logical := parquetLogicalType(element)
+	if element.IsSetLogicalType() {
+		switch {
+		case logical.IsSetDATE(), logical.IsSetTIMESTAMP():
+			return "DATE"
+		case logical.IsSetDECIMAL():
+			return "NUMERIC"
+		case logical.IsSetJSON():
+			return "JSON"
+		case logical.IsSetSTRING(), logical.IsSetENUM(), logical.IsSetUUID(), logical.IsSetTIME():
+			return "STRING"
+		}
+	}
+	if element.IsSetConvertedType() {
+		switch element.GetConvertedType() {
+		case parquet.ConvertedType_DATE, parquet.ConvertedType_TIMESTAMP_MILLIS, parquet.ConvertedType_TIMESTAMP_MICROS:
+			return "DATE"
+		case parquet.ConvertedType_DECIMAL:
+			return "NUMERIC"
+		case parquet.ConvertedType_JSON:
+			return "JSON"
+		case parquet.ConvertedType_TIME_MILLIS, parquet.ConvertedType_TIME_MICROS:
+			return "STRING"
+		}
+	}
+
+	switch element.GetType() {
+	case parquet.Type_BOOLEAN:
+		return "BOOLEAN"
+	case parquet.Type_INT32, parquet.Type_INT64:
+		return "INTEGER"
+	case parquet.Type_FLOAT, parquet.Type_DOUBLE:
+		return "NUMERIC"
+	case parquet.Type_INT96:
+		// legacy Impala and Spark timestamps
+		return "DATE"
+	}
+	return "STRING"
+}
+
+// parquetText :: a column value with invalid UTF-8 handled by the
+// invalid_byte_policy, for the columns annotated as text
+func parquetText(element *parquet.SchemaElement, value interface{}, invalid *invalidBytes) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || !parquetIsText(element) {
+		return value, nil
+	}
+	return invalid.sanitize(s)
+}
+
+// parquetIsText :: whether a byte array column is annotated as holding
+// text, rather than binary data
+func parquetIsText(element *parquet.SchemaElement) bool {
+	logical := parquetLogicalType(element)
+	if logical.IsSetSTRING() || logical.IsSetENUM() || logical.IsSetJSON() {
+		return true
+	}
+	if !element.IsSetConvertedType() {
+		return false
+	}
+	switch element.GetConvertedType() {
+	case parquet.ConvertedType_UTF8, parquet.ConvertedType_ENUM, parquet.ConvertedType_JSON:
+		return true
+	}
+	return false
+}
+
+// parquetValue :: convert a value read by parquet-go, which is the physical
+// type of the column, to the Go value of its column type
+func parquetValue(element *parquet.SchemaElement, value interface{}) interface{} {
+
+	if value == nil {
+		return nil
+	}
+	logical := parquetLogicalType(element)
+	converted := element.GetConvertedType()
+	if !element.IsSetConvertedType() {
+		converted = -1
+	}
+
+	switch v := value.(type) {
+	case bool, float64:
+		return v
+	case float32:
+		return float64(v)
+	case int32:
+		if logical.IsSetDATE() || converted == parquet.ConvertedType_DATE {
+			return time.Unix(int64(v)*86400, 0).UTC()
+		}
+		if logical.IsSetDECIMAL() || converted == parquet.ConvertedType_DECIMAL {
+			return float64(v) / math.Pow10(int(element.GetScale()))
+		}
+		if logical.IsSetTIME() || converted == parquet.ConvertedType_TIME_MILLIS {
+			return time.UnixMilli(int64(v)).UTC().Format("15:04:05.000")
+		}
+		if converted == parquet.ConvertedType_UINT_32 {
+			return int64(uint32(v))
+		}
+		return int64(v)
+	case int64:
+		if logical.IsSetTIMESTAMP() {
+			unit := logical.GetTIMESTAMP().GetUnit()
+			switch {
+			case unit != nil && unit.IsSetMILLIS():
+				return time.UnixMilli(v).UTC()
+			case unit != nil && unit.IsSetMICROS():
+				return time.UnixMicro(v).UTC()
+			default:
+				return time.Unix(0, v).UTC()
+			}
+		}
+		switch converted {
+		case parquet.ConvertedType_TIMESTAMP_MILLIS:
+			return time.UnixMilli(v).UTC()
+		case parquet.ConvertedType_TIMESTAMP_MICROS:
+			return time.UnixMicro(v).UTC()
+		}
+		if logical.IsSetDECIMAL() || converted == parquet.ConvertedType_DECIMAL {
+			return float64(v) / math.Pow10(int(element.GetScale()))
+		}
+		if logical.IsSetTIME() {
+			unit := logical.GetTIME().GetUnit()
+			if unit != nil && unit.IsSetNANOS() {
+				return time.Unix(0, v).UTC().Format("15:04:05.000000000")
+			}
+			return time.UnixMicro(v).UTC().Format("15:04:05.000000")
+		}
+		if converted == parquet.ConvertedType_TIME_MICROS {
+			return time.UnixMicro(v).UTC().Format("15:04:05.000000")
+		}
+		return v
+	case string:
+		// BYTE_ARRAY, FIXED_LEN_BYTE_ARRAY and INT96 are all read as strings
+		if element.GetType() == parquet.Type_INT96 && len(v) == 12 {
+			return types.INT96ToTime(v).UTC()
+		}
+		if logical.IsSetDECIMAL() || converted == parquet.ConvertedType_DECIMAL {
+			return decimalBytes(v, int(element.GetScale()))
+		}
+		if logical.IsSetUUID() && len(v) == 16 {
+			b := []byte(v)
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+		}
+		if logical.IsSetJSON() || converted == parquet.ConvertedType_JSON {
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(v), &parsed); err == nil {
+				return parsed
+			}
+		}
+		if !parquetIsText(element) {
+			// binary data, which need not be valid text
+			return hex.EncodeToString([]byte(v))
+		}
+		return v
+	}
+	return value
+}
+
+// decimalBytes :: a decimal stored as a big-endian two's complement integer
+func decimalBytes(s_value string, i_scale int) float64 {
+	unscaled := new(big.Int).SetBytes([]byte(s_value))
+	if len(s_value) > 0 && s_value[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(s_value)*8)))
+	}
+	f_value, _ := new(big.Float).Quo(new(big.Float).SetInt(unscaled), new(big.Float).SetFloat64(math.Pow10(i_scale))).Float64()
+	return f_value
+}
@@ -0,0 +1,362 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
+	"github.com/xuri/excelize/v2"
+)
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatXLSX,
+		mimeTypes:  []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "application/vnd.ms-excel.sheet.macroenabled.12"},
+		extensions: []string{".xlsx", ".xlsm"},
+		sniff: func(table tableConfig, head []byte) bool {
+			s_member := zipFirstMember(head)
+			for _, s_prefix := range []string{"[Content_Types].xml", "_rels/", "docProps/", "xl/"} {
+				if strings.HasPrefix(s_member, s_prefix) {
+					return true
+				}
+			}
+			return false
+		},
+		decoder: sheetDecoder{format: formatXLSX},
+	})
+	registerFormat(formatSpec{
+		name:       formatODS,
+		mimeTypes:  []string{"application/vnd.oasis.opendocument.spreadsheet"},
+		extensions: []string{".ods"},
+		// OpenDocument files start with an uncompressed mimetype member
+		sniff: func(table tableConfig, head []byte) bool {
+			return zipFirstMember(head) == "mimetype" && bytes.Contains(head, []byte("application/vnd.oasis.opendocument.spreadsheet"))
+		},
+		decoder: sheetDecoder{format: formatODS},
+	})
+}
+
+// zipFirstMember :: the name of the first member of zip data, from its
+// local file header, or "" if the data is not a zip file
+func zipFirstMember(head []byte) string {
+	if len(head) < 30 || !bytes.HasPrefix(head, []byte("PK\x03\x04")) {
+		return ""
+	}
+	i_length := int(head[26]) | int(head[27])<<8
+	if 30+i_length > len(head) {
+		return ""
+	}
+	return string(head[30 : 30+i_length])
+}
+
+// sheetDecoder reads workbooks, which hold one table per sheet
+type sheetDecoder struct {
+	format string
+}
+
+func (dec sheetDecoder) Rows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+	return newSheetRows(ctx, table, dec.format, r, s_url)
+}
+
+// Split :: one table per sheet of the workbook, named after the table and
+// the sheet
+func (dec sheetDecoder) Split(ctx context.Context, table tableConfig, r io.Reader, s_url string) ([]tableConfig, error) {
+
+	sa_sheets, _, err := parseWorkbook(ctx, table, r, s_url, dec.format, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []tableConfig
+	sm_names := map[string]string{}
+	for _, s_sheet := range sa_sheets {
+		s_name := table.Name + "_" + sheetTableName(s_sheet)
+		if s_other, ok := sm_names[s_name]; ok {
+			plugin.Logger(ctx).Warn("skipping sheet with the same table name as another", "table", s_name, "sheet", s_sheet, "other", s_other)
+			continue
+		}
+		sm_names[s_name] = s_sheet
+		sheet := table
+		sheet.Name = s_name
+		sheet.sheet = s_sheet
+		tables = append(tables, sheet)
+	}
+	if len(tables) == 0 {
+		plugin.Logger(ctx).Warn("no sheets found in workbook", "table", table.Name)
+	}
+	return tables, nil
+}
+
+// sheetTableName :: a table name suffix for a sheet, its name in lower case
+// with other characters replaced by "_"
+func sheetTableName(s_sheet string) string {
+	return strings.Trim(nonIdentifierRegex.ReplaceAllString(strings.ToLower(s_sheet), "_"), "_")
+}
+
+// parseWorkbook :: read a whole workbook, which can not be truncated at
+// max_bytes like text, and return the names of its sheets, and the cells of
+// the named sheet if any. A workbook larger than max_bytes fails unless
+// max_bytes_policy is warn. Cells hold native values: string, bool, int64,
+// float64 or time.Time, and nil when empty.
+func parseWorkbook(ctx context.Context, table tableConfig, r io.Reader, s_url string, s_format string, s_sheet string) ([]string, [][]interface{}, error) {
+
+	s_policy, err := maxBytesPolicy(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	i_max_bytes := maxBytes(table)
+	if i_max_bytes > 0 && s_policy != maxBytesWarn {
+		r = io.LimitReader(r, i_max_bytes+1)
+	}
+	buff, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %v", s_url, err)
+	}
+	if i_max_bytes > 0 && int64(len(buff)) > i_max_bytes {
+		if s_policy != maxBytesWarn {
+			return nil, nil, fmt.Errorf("%s is larger than max_bytes of %d, a spreadsheet must be read whole", s_url, i_max_bytes)
+		}
+		plugin.Logger(ctx).Warn("data is larger than max_bytes", "table", table.Name, "max_bytes", i_max_bytes, "bytes_read", len(buff))
+	}
+	if s_format == formatODS {
+		return readODS(buff, s_url, s_sheet)
+	}
+	return readXLSX(buff, s_url, s_sheet)
+}
+
+// readXLSX :: the sheet names of an Excel workbook, and the cells of one sheet
+func readXLSX(buff []byte, s_url string, s_sheet string) ([]string, [][]interface{}, error) {
+
+	f, err := excelize.OpenReader(bytes.NewReader(buff))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading xlsx workbook %s: %v", s_url, err)
+	}
+	defer f.Close()
+
+	sa_sheets := f.GetSheetList()
+	if s_sheet == "" {
+		return sa_sheets, nil, nil
+	}
+
+	props, _ := f.GetWorkbookProps()
+	b_1904 := props.Date1904 != nil && *props.Date1904
+
+	raw, err := f.GetRows(s_sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading sheet %s of %s: %v", s_sheet, s_url, err)
+	}
+
+	// number formats are shared by many cells, remember which are dates
+	sm_date_styles := map[int]bool{}
+	cells := make([][]interface{}, len(raw))
+	for i_row, sa_row := range raw {
+		cells[i_row] = make([]interface{}, len(sa_row))
+		for i_col, s_value := range sa_row {
+			if s_value == "" {
+				continue
+			}
+			s_cell, _ := excelize.CoordinatesToCellName(i_col+1, i_row+1)
+			cell_type, _ := f.GetCellType(s_sheet, s_cell)
+			switch cell_type {
+			case excelize.CellTypeBool:
+				cells[i_row][i_col] = s_value == "1" || strings.EqualFold(s_value, "true")
+			case excelize.CellTypeDate:
+				if t, err := time.Parse(time.RFC3339, s_value); err == nil {
+					cells[i_row][i_col] = t
+				} else {
+					cells[i_row][i_col] = s_value
+				}
+			case excelize.CellTypeError:
+				// #DIV/0! and the like read as null
+			case excelize.CellTypeNumber, excelize.CellTypeUnset:
+				f_value, err := strconv.ParseFloat(s_value, 64)
+				if err != nil {
+					cells[i_row][i_col] = s_value
+					continue
+				}
+				i_style, _ := f.GetCellStyle(s_sheet, s_cell)
+				b_date, ok := sm_date_styles[i_style]
+				if !ok {
+					b_date = isDateStyle(f, i_style)
+					sm_date_styles[i_style] = b_date
+				}
+				if b_date {
+					if t, err := excelize.ExcelDateToTime(f_value, b_1904); err == nil {
+						cells[i_row][i_col] = t
+						continue
+					}
+				}
+				cells[i_row][i_col] = sheetNumber(f_value)
+			default:
+				cells[i_row][i_col] = s_value
+			}
+		}
+	}
+	return sa_sheets, cells, nil
+}
+
+// isDateStyle :: whether a cell style formats numbers as dates or times,
+// which is how Excel stores them
+func isDateStyle(f *excelize.File, i_style int) bool {
+	style, err := f.GetStyle(i_style)
+	if err != nil || style == nil {
+		return false
+	}
+	if style.CustomNumFmt != nil {
+		// ignore quoted text and colours, then look for date or time parts
+		s_format := strings.ToLower(*style.CustomNumFmt)
+		var sb strings.Builder
+		b_quoted := false
+		b_bracket := false
+		for _, r := range s_format {
+			switch {
+			case r == '"':
+				b_quoted = !b_quoted
+			case b_quoted:
+			case r == '[':
+				b_bracket = true
+			case r == ']':
+				b_bracket = false
+			case !b_bracket:
+				sb.WriteRune(r)
+			}
+		}
+		return strings.ContainsAny(sb.String(), "ymdhs")
+	}
+	// built in date and time formats
+	switch {
+	case style.NumFmt >= 14 && style.NumFmt <= 22,
+		style.NumFmt >= 27 && style.NumFmt <= 36,
+		style.NumFmt >= 45 && style.NumFmt <= 47,
+		style.NumFmt >= 50 && style.NumFmt <= 58:
+		return true
+	}
+	return false
+}
+
+// sheetNumber :: spreadsheets store every number as a float, whole numbers
+// are returned as int64 so that columns of counts are integers
+func sheetNumber(f_value float64) interface{} {
+	if f_value == math.Trunc(f_value) && math.Abs(f_value) < 1<<53 {
+		return int64(f_value)
+	}
+	return f_value
+}
+
+// sheetRows reads the rows of one sheet of a workbook
+type sheetRows struct {
+	cells   [][]interface{}
+	columns []string
+	next    int
+}
+
+// newSheetRows :: read a sheet and find its header row, after skipping
+// skip_rows rows. header_row picks the header among the remaining rows.
+func newSheetRows(ctx context.Context, table tableConfig, s_format string, r io.Reader, s_url string) (*sheetRows, error) {
+
+	s_header_mode, err := headerMode(table)
+	if err != nil {
+		return nil, err
+	}
+	i_skip := int64(0)
+	if table.SkipRows != nil {
+		i_skip = *table.SkipRows
+	}
+	i_header_row := int64(1)
+	if table.HeaderRow != nil {
+		i_header_row = *table.HeaderRow
+	}
+	if i_skip < 0 {
+		return nil, fmt.Errorf("table %s: skip_rows must not be negative, got %d", table.Name, i_skip)
+	}
+	if i_header_row < 1 {
+		return nil, fmt.Errorf("table %s: header_row must be 1 or more, got %d", table.Name, i_header_row)
+	}
+	if table.sheet == "" {
+		return nil, fmt.Errorf("table %s: %s is a spreadsheet, which is read one table per sheet: set format = %q", table.Name, s_url, s_format)
+	}
+
+	_, cells, err := parseWorkbook(ctx, table, r, s_url, s_format, table.sheet)
+	if err != nil {
+		return nil, err
+	}
+	if i_skip > int64(len(cells)) {
+		i_skip = int64(len(cells))
+	}
+	cells = cells[i_skip:]
+
+	rows := &sheetRows{}
+	if s_header_mode != "false" && i_header_row <= int64(len(cells)) {
+		header := cells[i_header_row-1]
+		for len(header) > 0 && header[len(header)-1] == nil {
+			header = header[:len(header)-1]
+		}
+		sa_header := make([]string, len(header))
+		b_text := true
+		for idx, value := range header {
+			s_value, ok := value.(string)
+			if !ok && value != nil {
+				b_text = false
+				s_value = fmt.Sprint(value)
+			}
+			sa_header[idx] = strings.TrimSpace(s_value)
+		}
+		if s_header_mode == "true" || b_text && hasHeader(sa_header, s_header_mode) {
+			if ok, s_message := validHeader(ctx, sa_header); !ok {
+				return nil, fmt.Errorf("%s sheet %s: %s", s_url, table.sheet, s_message)
+			}
+			rows.columns = sa_header
+			cells = cells[i_header_row:]
+		} else {
+			cells = cells[i_header_row-1:]
+		}
+	}
+
+	// blank rows separate blocks of data in many sheets, they are not rows
+	for _, row := range cells {
+		for _, value := range row {
+			if value != nil {
+				rows.cells = append(rows.cells, row)
+				break
+			}
+		}
+	}
+	if rows.columns == nil {
+		i_width := 0
+		for _, row := range rows.cells {
+			for idx := len(row) - 1; idx >= i_width; idx-- {
+				if row[idx] != nil {
+					i_width = idx + 1
+					break
+				}
+			}
+		}
+		rows.columns = generatedColumns(i_width)
+	}
+	return rows, nil
+}
+
+func (rows *sheetRows) Columns() []string {
+	return rows.columns
+}
+
+// Next maps the next row onto the column names, ignoring surplus cells
+func (rows *sheetRows) Next() (map[string]interface{}, error) {
+	if rows.next >= len(rows.cells) {
+		return nil, io.EOF
+	}
+	sm_row := map[string]interface{}{}
+	for idx, value := range rows.cells[rows.next] {
+		if idx < len(rows.columns) && value != nil {
+			sm_row[rows.columns[idx]] = value
+		}
+	}
+	rows.next++
+	return sm_row, nil
+}
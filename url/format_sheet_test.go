@@ -0,0 +1,57 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// sheetSplitCases are the sheets of a workbook and the tables they give,
+// with sheets whose table name is taken by an earlier sheet skipped
+var sheetSplitCases = []struct {
+	sheets []string
+	tables []string
+}{
+	{[]string{"Sheet1"}, []string{"t_sheet1"}},
+	{[]string{"Sheet1", "Sales 2020", "Q1-Q2 (draft)"}, []string{"t_sheet1", "t_sales_2020", "t_q1_q2_draft"}},
+	{[]string{"Sales 2020", "sales_2020", "SALES-2020 "}, []string{"t_sales_2020"}},
+}
+
+func TestSheetSplit(t *testing.T) {
+	for _, c := range sheetSplitCases {
+		workbook := excelize.NewFile()
+		for idx, s_sheet := range c.sheets {
+			if idx == 0 {
+				workbook.SetSheetName("Sheet1", s_sheet)
+			} else if _, err := workbook.NewSheet(s_sheet); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := workbook.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		tables, err := sheetDecoder{format: formatXLSX}.Split(context.Background(), tableConfig{Name: "t"}, &buf, "test.xlsx")
+		if err != nil {
+			t.Errorf("%q: %v", c.sheets, err)
+			continue
+		}
+		var sa_tables []string
+		for _, table := range tables {
+			sa_tables = append(sa_tables, table.Name)
+		}
+		if len(sa_tables) != len(c.tables) {
+			t.Errorf("%q: tables %q, want %q", c.sheets, sa_tables, c.tables)
+			continue
+		}
+		for idx := range sa_tables {
+			if sa_tables[idx] != c.tables[idx] {
+				t.Errorf("%q: tables %q, want %q", c.sheets, sa_tables, c.tables)
+				break
+			}
+		}
+	}
+}
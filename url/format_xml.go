@@ -0,0 +1,238 @@
+package url
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatXML,
+		mimeTypes:  []string{"application/xml", "text/xml", "application/atom+xml", "application/rss+xml"},
+		extensions: []string{".xml", ".atom", ".rss"},
+		text:       true,
+		sniff: func(table tableConfig, head []byte) bool {
+			return bytes.HasPrefix(sniffText(head), []byte("<")) && !isHTML(head)
+		},
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newXMLRows(table, r, s_url)
+		}),
+	})
+}
+
+// xmlRows reads rows from the elements of an XML document matching
+// record_path
+type xmlRows struct {
+	decoder  *xml.Decoder
+	url      string
+	steps    []string
+	anywhere bool
+	stack    []string // local names of the open elements
+	columns  []string
+	seen     map[string]bool
+}
+
+// newXMLRows :: prepare a decoder over the document. Without record_path
+// the children of the root element are the rows.
+func newXMLRows(table tableConfig, r io.Reader, s_url string) (*xmlRows, error) {
+
+	sa_steps, b_anywhere, err := parseRecordPath(stringValue(table.RecordPath))
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	rows := &xmlRows{
+		decoder:  xml.NewDecoder(r),
+		url:      s_url,
+		steps:    sa_steps,
+		anywhere: b_anywhere,
+		seen:     map[string]bool{},
+	}
+	// the data has already been transcoded to UTF-8 following the
+	// declaration, which the decoder would otherwise reject
+	rows.decoder.CharsetReader = func(s_charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return rows, nil
+}
+
+// parseRecordPath :: split a path such as "/feed/entry" into element names.
+// A path starting with "//", or not starting with "/", matches at any
+// depth. Namespace prefixes are ignored and "*" matches any element.
+func parseRecordPath(s_path string) ([]string, bool, error) {
+
+	s_path = strings.TrimSpace(s_path)
+	if s_path == "" {
+		return []string{"*", "*"}, false, nil
+	}
+	if strings.ContainsAny(s_path, "[]@()") {
+		return nil, false, fmt.Errorf("record_path %q: only element names and * are supported", s_path)
+	}
+	b_anywhere := !strings.HasPrefix(s_path, "/") || strings.HasPrefix(s_path, "//")
+	var sa_steps []string
+	for _, s_step := range strings.Split(strings.Trim(s_path, "/"), "/") {
+		if s_step == "" {
+			return nil, false, fmt.Errorf("record_path %q: // is only supported at the start", s_path)
+		}
+		if i_colon := strings.Index(s_step, ":"); i_colon >= 0 {
+			s_step = s_step[i_colon+1:]
+		}
+		sa_steps = append(sa_steps, s_step)
+	}
+	return sa_steps, b_anywhere, nil
+}
+
+// matches :: whether the open element is a record
+func (rows *xmlRows) matches() bool {
+	if len(rows.stack) < len(rows.steps) || !rows.anywhere && len(rows.stack) != len(rows.steps) {
+		return false
+	}
+	sa_tail := rows.stack[len(rows.stack)-len(rows.steps):]
+	for idx, s_step := range rows.steps {
+		if s_step != "*" && s_step != sa_tail[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func (rows *xmlRows) Columns() []string {
+	return rows.columns
+}
+
+func (rows *xmlRows) Next() (map[string]interface{}, error) {
+	for {
+		token, err := rows.decoder.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rows.url, err)
+		}
+		switch t := token.(type) {
+		case xml.EndElement:
+			rows.stack = rows.stack[:len(rows.stack)-1]
+		case xml.StartElement:
+			rows.stack = append(rows.stack, t.Name.Local)
+			if !rows.matches() {
+				continue
+			}
+			// the record is read whole, so its end is consumed here
+			rows.stack = rows.stack[:len(rows.stack)-1]
+			value, err := xmlNode(rows.decoder, t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", rows.url, err)
+			}
+			return rows.row(value), nil
+		}
+	}
+}
+
+// row :: the attributes and children of a record are its columns, new ones
+// are added in document order
+func (rows *xmlRows) row(value interface{}) map[string]interface{} {
+	object, ok := value.(*xmlObject)
+	if !ok {
+		object = &xmlObject{values: map[string]interface{}{}}
+		if value != nil {
+			object.set("value", value)
+		}
+	}
+	for _, s_key := range object.keys {
+		if !rows.seen[s_key] {
+			rows.seen[s_key] = true
+			rows.columns = append(rows.columns, s_key)
+		}
+	}
+	return object.values
+}
+
+// xmlObject is an element with attributes or children, keeping the order
+// its keys first appeared in
+type xmlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *xmlObject) set(s_key string, value interface{}) {
+	if _, ok := o.values[s_key]; !ok {
+		o.keys = append(o.keys, s_key)
+	}
+	o.values[s_key] = value
+}
+
+// xmlValue :: nested elements are returned as plain maps for JSON columns
+func xmlValue(value interface{}) interface{} {
+	if object, ok := value.(*xmlObject); ok {
+		return object.values
+	}
+	return value
+}
+
+// xmlNode :: read an element up to its end. An element with only text is
+// its text, or nil when empty. Other elements are objects of attributes and
+// children, where repeated children become a list, text mixed in with
+// children is kept as value, and an attribute sharing its name with a child
+// is renamed @name.
+func xmlNode(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+
+	object := &xmlObject{values: map[string]interface{}{}}
+	var sb strings.Builder
+	var sa_children []string
+	children := map[string][]interface{}{}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			sb.Write(t)
+		case xml.StartElement:
+			child, err := xmlNode(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := children[t.Name.Local]; !ok {
+				sa_children = append(sa_children, t.Name.Local)
+			}
+			children[t.Name.Local] = append(children[t.Name.Local], xmlValue(child))
+		case xml.EndElement:
+			s_text := strings.TrimSpace(sb.String())
+			var sa_attrs []xml.Attr
+			for _, attr := range start.Attr {
+				// namespace declarations are not data
+				if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+					sa_attrs = append(sa_attrs, attr)
+				}
+			}
+			if len(sa_attrs) == 0 && len(sa_children) == 0 {
+				if s_text == "" {
+					return nil, nil
+				}
+				return s_text, nil
+			}
+			for _, attr := range sa_attrs {
+				s_key := attr.Name.Local
+				if _, ok := children[s_key]; ok {
+					s_key = "@" + s_key
+				}
+				object.set(s_key, attr.Value)
+			}
+			for _, s_name := range sa_children {
+				if len(children[s_name]) == 1 {
+					object.set(s_name, children[s_name][0])
+				} else {
+					object.set(s_name, children[s_name])
+				}
+			}
+			if s_text != "" {
+				object.set("value", s_text)
+			}
+			return object, nil
+		}
+	}
+}
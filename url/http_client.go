@@ -0,0 +1,175 @@
+package url
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of the redirect_policy option
+const (
+	redirectFollow   = "follow"    // follow up to max_redirects redirects
+	redirectSameHost = "same_host" // only follow redirects to the same host
+	redirectNone     = "none"      // return the redirect response as is
+)
+
+const defaultMaxRedirects = 10
+
+// clientCache reuses one client per distinct transport configuration so
+// that connections are kept alive across queries
+var clientCache = struct {
+	sync.Mutex
+	clients map[string]*http.Client
+}{clients: map[string]*http.Client{}}
+
+// httpClient :: the HTTP client for a table, built from its TLS, proxy,
+// redirect and timeout options. The overall timeout is applied per fetch.
+func httpClient(table tableConfig) (*http.Client, error) {
+
+	connectTimeout, err := durationOption(table.ConnectTimeout, "connect_timeout", defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	readTimeout, err := durationOption(table.ReadTimeout, "read_timeout", defaultReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	i_max_redirects := int64(-1)
+	if table.MaxRedirects != nil {
+		i_max_redirects = *table.MaxRedirects
+	}
+	s_key := fmt.Sprintf("%q %q %q %t %q %q %d %s %s",
+		stringValue(table.TLSClientCert), stringValue(table.TLSClientKey), table.TLSCABundles,
+		table.TLSInsecureSkipVerify != nil && *table.TLSInsecureSkipVerify,
+		stringValue(table.ProxyURL), stringValue(table.RedirectPolicy), i_max_redirects,
+		connectTimeout, readTimeout,
+	)
+
+	clientCache.Lock()
+	defer clientCache.Unlock()
+	if client, ok := clientCache.clients[s_key]; ok {
+		return client, nil
+	}
+
+	tlsConfig, err := clientTLSConfig(table)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = readTimeout
+	transport.TLSClientConfig = tlsConfig
+	if table.ProxyURL != nil && *table.ProxyURL != "" {
+		proxyURL, err := url.Parse(*table.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %v", err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("proxy_url scheme must be http, https or socks5, got %q", proxyURL.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	checkRedirect, err := redirectPolicy(table)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+	clientCache.clients[s_key] = client
+	return client, nil
+}
+
+// clientTLSConfig :: client certificate, CA bundles and verification settings
+func clientTLSConfig(table tableConfig) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{}
+
+	if table.TLSInsecureSkipVerify != nil && *table.TLSInsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	s_cert, s_key := stringValue(table.TLSClientCert), stringValue(table.TLSClientKey)
+	if s_cert != "" || s_key != "" {
+		if s_cert == "" || s_key == "" {
+			return nil, errors.New("tls_client_cert and tls_client_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(s_cert, s_key)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(table.TLSCABundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, s_path := range table.TLSCABundles {
+			pem, err := os.ReadFile(s_path)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA bundle: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", s_path)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// redirectPolicy :: the CheckRedirect function for the redirect options
+func redirectPolicy(table tableConfig) (func(*http.Request, []*http.Request) error, error) {
+
+	i_max := int64(defaultMaxRedirects)
+	if table.MaxRedirects != nil {
+		i_max = *table.MaxRedirects
+	}
+
+	s_policy := redirectFollow
+	if table.RedirectPolicy != nil && *table.RedirectPolicy != "" {
+		s_policy = strings.ToLower(*table.RedirectPolicy)
+	}
+
+	switch s_policy {
+	case redirectNone:
+		return func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}, nil
+	case redirectFollow, redirectSameHost:
+		return func(req *http.Request, via []*http.Request) error {
+			if int64(len(via)) > i_max {
+				return fmt.Errorf("stopped after %d redirects", i_max)
+			}
+			if s_policy == redirectSameHost && req.URL.Host != via[0].URL.Host {
+				return fmt.Errorf("redirect to another host %s not allowed by redirect_policy", req.URL.Host)
+			}
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("redirect_policy must be one of follow, same_host or none, got %q", *table.RedirectPolicy)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,165 @@
+package url
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryLeeway renews access tokens a little before they expire
+const tokenExpiryLeeway = 30 * time.Second
+
+// oauth2Token is an access token issued by a token endpoint
+type oauth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	expiry       time.Time
+}
+
+func (t *oauth2Token) valid() bool {
+	return t != nil && t.AccessToken != "" && (t.expiry.IsZero() || time.Now().Add(tokenExpiryLeeway).Before(t.expiry))
+}
+
+// tokenCache holds access tokens across queries, keyed by token endpoint and
+// client. Rotated refresh tokens are kept here too, so that a refresh token
+// from the config is only used until the endpoint issues a new one. The
+// cache lock only guards the map: each key has its own lock, held while its
+// token is requested, so that a slow endpoint does not hold up the others.
+var tokenCache = struct {
+	sync.Mutex
+	entries map[string]*tokenEntry
+}{entries: map[string]*tokenEntry{}}
+
+type tokenEntry struct {
+	sync.Mutex
+	token *oauth2Token
+}
+
+// oauth2TokenEntry :: the cache entry for a key, added if missing
+func oauth2TokenEntry(s_key string) *tokenEntry {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+
+	entry := tokenCache.entries[s_key]
+	if entry == nil {
+		entry = &tokenEntry{}
+		tokenCache.entries[s_key] = entry
+	}
+	return entry
+}
+
+// oauth2Enabled :: whether the table fetches its access token from a token endpoint
+func oauth2Enabled(table tableConfig) bool {
+	return table.OAuth2TokenURL != nil && *table.OAuth2TokenURL != ""
+}
+
+func oauth2CacheKey(table tableConfig) string {
+	s_key := *table.OAuth2TokenURL
+	if table.OAuth2ClientID != nil {
+		s_key += "|" + *table.OAuth2ClientID
+	}
+	if table.OAuth2RefreshToken != nil {
+		s_key += "|" + *table.OAuth2RefreshToken
+	}
+	return s_key + "|" + strings.Join(table.OAuth2Scopes, " ")
+}
+
+// oauth2AccessToken :: return a cached access token for the table, asking
+// the token endpoint for a new one when there is none, it has expired or
+// renew is set. The refresh_token grant is used when a refresh token is
+// configured, client_credentials otherwise.
+func oauth2AccessToken(ctx context.Context, table tableConfig, renew bool) (string, error) {
+
+	entry := oauth2TokenEntry(oauth2CacheKey(table))
+	entry.Lock()
+	defer entry.Unlock()
+
+	cached := entry.token
+	if !renew && cached.valid() {
+		return cached.AccessToken, nil
+	}
+
+	s_client_secret, err := optionalSecret(table.OAuth2ClientSecret, "oauth2_client_secret")
+	if err != nil {
+		return "", err
+	}
+	s_refresh_token, err := optionalSecret(table.OAuth2RefreshToken, "oauth2_refresh_token")
+	if err != nil {
+		return "", err
+	}
+	if cached != nil && cached.RefreshToken != "" {
+		s_refresh_token = cached.RefreshToken
+	}
+
+	form := url.Values{}
+	if s_refresh_token != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", s_refresh_token)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if table.OAuth2ClientID != nil {
+		form.Set("client_id", *table.OAuth2ClientID)
+	}
+	if s_client_secret != "" {
+		form.Set("client_secret", s_client_secret)
+	}
+	if len(table.OAuth2Scopes) > 0 {
+		form.Set("scope", strings.Join(table.OAuth2Scopes, " "))
+	}
+
+	client, err := httpClient(table)
+	if err != nil {
+		return "", err
+	}
+	token, err := requestToken(ctx, client, *table.OAuth2TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = s_refresh_token
+	}
+	entry.token = token
+
+	return token.AccessToken, nil
+}
+
+// requestToken :: POST a grant to the token endpoint
+func requestToken(ctx context.Context, client *http.Client, s_token_url string, form url.Values) (*oauth2Token, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s_token_url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid oauth2_token_url %q: %v", s_token_url, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token request to %s failed: %v", s_token_url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("oauth2 token request to %s returned %s: %s", s_token_url, resp.Status, bodySnippet(resp.Body))
+	}
+
+	token := &oauth2Token{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, fmt.Errorf("oauth2 token response from %s: %v", s_token_url, err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2 token response from %s has no access_token", s_token_url)
+	}
+	if token.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
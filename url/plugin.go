@@ -2,6 +2,7 @@ package url
 
 import (
 	"context"
+	"fmt"
 	// "errors"
 	// "os"
 	// "path/filepath"
@@ -9,7 +10,6 @@ import (
 
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin"
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
-
 	// "github.com/davecgh/go-spew/spew"
 )
 
@@ -26,11 +26,48 @@ func Plugin(ctx context.Context) *plugin.Plugin {
 	return p
 }
 
-
 func PluginTables(ctx context.Context, d *plugin.TableMapData) (map[string]*plugin.Table, error) {
 
 	tables := map[string]*plugin.Table{}
-	tables["http"] = tableData(ctx, d.Connection)
+	urlConfig := GetConfig(d.Connection)
+	for _, configured := range urlConfig.tableConfigs() {
+		members, err := archiveTables(ctx, configured)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 0 && members[0].download != nil {
+			// queries download the archive for themselves
+			defer members[0].download.remove()
+		}
+		var expanded []tableConfig
+		for _, member := range members {
+			sheets, err := splitTables(ctx, member)
+			if err != nil && member.member != "" {
+				plugin.Logger(ctx).Warn("skipping archive member", "table", member.Name, "error", err.Error())
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, sheets...)
+		}
+		for _, table := range expanded {
+			if _, ok := tables[table.Name]; ok {
+				return nil, fmt.Errorf("duplicate table name %q in connection config", table.Name)
+			}
+			tableDef, err := tableData(ctx, table)
+			if err != nil && (table.member != "" || table.sheet != "") {
+				// one unreadable file or sheet should not hide the rest of the
+				// archive or workbook
+				plugin.Logger(ctx).Warn("skipping table", "table", table.Name, "error", err.Error())
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			tables[table.Name] = tableDef
+		}
+	}
 
 	return tables, nil
 
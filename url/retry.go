@@ -0,0 +1,187 @@
+package url
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the timeout and retry options
+const (
+	defaultConnectTimeout  = 30 * time.Second
+	defaultReadTimeout     = 60 * time.Second
+	defaultMaxRetries      = 3
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+// retrySettings are the parsed retry options of a table
+type retrySettings struct {
+	maxRetries int64
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func tableRetrySettings(table tableConfig) (retrySettings, error) {
+	settings := retrySettings{maxRetries: defaultMaxRetries}
+	if table.MaxRetries != nil {
+		settings.maxRetries = *table.MaxRetries
+	}
+	var err error
+	if settings.backoff, err = durationOption(table.RetryBackoff, "retry_backoff", defaultRetryBackoff); err != nil {
+		return settings, err
+	}
+	if settings.maxBackoff, err = durationOption(table.RetryMaxBackoff, "retry_max_backoff", defaultRetryMaxBackoff); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// durationOption :: parse a duration option such as "30s", 0 disables it
+func durationOption(s_value *string, s_option string, fallback time.Duration) (time.Duration, error) {
+	if s_value == nil || *s_value == "" {
+		return fallback, nil
+	}
+	if *s_value == "0" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(*s_value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%s must be a duration such as \"30s\", got %q", s_option, *s_value)
+	}
+	return duration, nil
+}
+
+// retryReason :: why a response or error is worth retrying, or "" if it is not
+func retryReason(ctx context.Context, resp *http.Response, err error) string {
+	if ctx.Err() != nil {
+		return ""
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return err.Error()
+		}
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status
+	}
+	return ""
+}
+
+// retryDelay :: exponential backoff for the given attempt, starting at 0,
+// unless the response says how long to wait with Retry-After. Either is
+// capped at retry_max_backoff, so that a server cannot stall the query.
+func retryDelay(settings retrySettings, resp *http.Response, attempt int64) time.Duration {
+	delay := settings.backoff
+	for i := int64(0); i < attempt && delay < settings.maxBackoff; i++ {
+		delay *= 2
+	}
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			delay = after
+		}
+	}
+	if delay > settings.maxBackoff {
+		delay = settings.maxBackoff
+	}
+	return delay
+}
+
+// retryAfter :: the delay given by a Retry-After header, in seconds or as
+// an HTTP date, and whether it held one
+func retryAfter(s_after string) (time.Duration, bool) {
+	if s_after == "" {
+		return 0, false
+	}
+	if i_seconds, err := strconv.ParseInt(s_after, 10, 64); err == nil && i_seconds >= 0 {
+		if i_seconds > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64, true
+		}
+		return time.Duration(i_seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(s_after); err == nil {
+		if delay := time.Until(when); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// sleepContext :: wait for the delay unless the context is done first
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// timeoutBody cancels a response once a read has waited readTimeout for
+// data, and releases the request context when closed. Only the time spent
+// in Read counts, not the time the consumer takes between reads.
+type timeoutBody struct {
+	body        io.ReadCloser
+	url         string
+	readTimeout time.Duration
+	cancel      context.CancelFunc
+	timer       *time.Timer
+	mu          sync.Mutex
+	timedOut    bool
+}
+
+func newTimeoutBody(body io.ReadCloser, s_url string, readTimeout time.Duration, cancel context.CancelFunc) *timeoutBody {
+	b := &timeoutBody{body: body, url: s_url, readTimeout: readTimeout, cancel: cancel}
+	if readTimeout > 0 {
+		b.timer = time.AfterFunc(readTimeout, func() {
+			b.mu.Lock()
+			b.timedOut = true
+			b.mu.Unlock()
+			cancel()
+		})
+		// armed only while a read is waiting
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	if b.timer != nil {
+		b.timer.Reset(b.readTimeout)
+	}
+	n, err := b.body.Read(p)
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		timedOut := b.timedOut
+		b.mu.Unlock()
+		if timedOut {
+			return n, fmt.Errorf("no data received from %s for %s", b.url, b.readTimeout)
+		}
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
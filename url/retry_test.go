@@ -0,0 +1,42 @@
+package url
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// retryDelayCases are the waits before a retry, by attempt and Retry-After,
+// with retry_backoff of 1s and retry_max_backoff of 30s
+var retryDelayCases = []struct {
+	attempt    int64
+	retryAfter string
+	delay      time.Duration
+}{
+	{0, "", time.Second},
+	{1, "", 2 * time.Second},
+	{4, "", 16 * time.Second},
+	{5, "", 30 * time.Second},
+	{40, "", 30 * time.Second},
+	{0, "0", 0},
+	{0, "5", 5 * time.Second},
+	{0, "86400", 30 * time.Second},
+	{0, "99999999999999999999", time.Second},
+	{0, "9223372036854775807", 30 * time.Second},
+	{0, "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+	{0, time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), 30 * time.Second},
+	{2, "soon", 4 * time.Second},
+}
+
+func TestRetryDelay(t *testing.T) {
+	settings := retrySettings{maxRetries: 3, backoff: time.Second, maxBackoff: 30 * time.Second}
+	for _, c := range retryDelayCases {
+		resp := &http.Response{Header: http.Header{}}
+		if c.retryAfter != "" {
+			resp.Header.Set("Retry-After", c.retryAfter)
+		}
+		if delay := retryDelay(settings, resp, c.attempt); delay != c.delay {
+			t.Errorf("attempt %d, Retry-After %q: waited %v, want %v", c.attempt, c.retryAfter, delay, c.delay)
+		}
+	}
+}
@@ -1,13 +1,15 @@
 package url
 
 import (
-	// "compress/gzip"
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
 	// "os"
 	"strings"
+	"time"
 
 	// "github.com/dimchansky/utfbom"
 	"github.com/turbot/go-kit/helpers"
@@ -17,8 +19,7 @@ import (
 	"github.com/turbot/steampipe-plugin-sdk/v5/plugin/transform"
 	// "github.com/hashicorp/go-hclog"
 	// "github.com/turbot/steampipe-plugin-sdk/v5/grpc/proto"
-
-	"net/http"
+	// "net/http"
 	// "github.com/davecgh/go-spew/spew"
 	// "sync"
 	// "time"
@@ -27,160 +28,522 @@ import (
 	// "unicode"
 )
 
+func tableData(ctx context.Context, table tableConfig) (*plugin.Table, error) {
 
-func tableData(ctx context.Context, connection *plugin.Connection) (*plugin.Table) {
-
-	urlConfig := GetConfig(connection)
 	var dataURL string
-	if urlConfig.DataURL != nil {
-		dataURL = *urlConfig.DataURL
+	if table.DataURL != nil {
+		dataURL = *table.DataURL
+	}
+	s_description := "Data read from " + dataURL
+	if table.member != "" {
+		s_description = "Data read from " + table.member + " in " + dataURL
+	}
+	if table.sheet != "" {
+		s_description = "Data read from sheet " + table.sheet + " of " + dataURL
+		if table.member != "" {
+			s_description = "Data read from sheet " + table.sheet + " of " + table.member + " in " + dataURL
+		}
 	}
 
 	cols := []*plugin.Column{}
 
-	sa_rows, sa_column_map, _ := readData(ctx, dataURL)
-	for s_column_name, s_column_type := range sa_column_map {
+	// only a sample of the data is read to build the schema, the rows
+	// themselves are fetched again by the List hydrate at query time
+	sa_columns, sa_column_map, err := readSchema(ctx, table)
+	if err != nil {
+		plugin.Logger(ctx).Error("tableData Error < " + err.Error() + " >")
+		return nil, fmt.Errorf("table %s: %v", table.Name, err)
+	}
+	for _, s_column_name := range sa_columns {
+		s_column_type := sa_column_map[s_column_name]
 		if s_column_type == "INTEGER" {
 			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_INT, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
 		} else if s_column_type == "NUMERIC" {
 			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_DOUBLE, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
 		} else if s_column_type == "DATE" || s_column_type == "TIMESTAMP" {
 			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_TIMESTAMP, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
+		} else if s_column_type == "BOOLEAN" {
+			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_BOOL, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
+		} else if s_column_type == "JSON" {
+			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_JSON, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
 		} else {
 			cols = append(cols, &plugin.Column{Name: s_column_name, Type: proto.ColumnType_STRING, Transform: transform.FromField(helpers.EscapePropertyName(s_column_name))})
 		}
 	}
+	// _invalid_bytes counts substitutions unless the data has such a column
+	_, b_data_column := sa_column_map[s_invalid_bytes_column]
+	if !b_data_column {
+		cols = append(cols, &plugin.Column{Name: s_invalid_bytes_column, Type: proto.ColumnType_INT, Description: "Invalid bytes substituted by invalid_byte_policy in the data of the query read up to this row, so the last row has the total of the fetch", Transform: transform.FromField(s_invalid_bytes_column)})
+	}
 
-
-	return &plugin.Table {
-		Name: "http",
+	return &plugin.Table{
+		Name:        table.Name,
+		Description: s_description,
 		List: &plugin.ListConfig{
-			Hydrate: listDataWithURL(sa_rows),
+			Hydrate: listDataWithURL(table, !b_data_column),
 		},
 		Columns: cols,
-	}
+	}, nil
 }
 
-
-func listDataWithURL (sa_rows []map[string]string) func(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
+func listDataWithURL(table tableConfig, b_invalid_bytes bool) func(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
 	return func(ctx context.Context, d *plugin.QueryData, h *plugin.HydrateData) (interface{}, error) {
-		for _, sm_row := range sa_rows {
+		spec, err := tableFormat(table)
+		if err != nil {
+			return nil, err
+		}
+		if spec != nil {
+			if ranged, ok := spec.decoder.(rangeDecoder); ok {
+				if err := ranged.List(ctx, d, table); err != nil {
+					return nil, fmt.Errorf("table %s: %v", table.Name, err)
+				}
+				return nil, nil
+			}
+		}
+
+		stream, err := openRows(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		// closing the body stops the fetch once enough rows were streamed
+		defer stream.Close()
+		defer stream.invalid.log(ctx, table)
+
+		for {
+			sm_row, err := stream.Next()
+			if err == io.EOF {
+				stream.logExceeded(ctx, table)
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("table %s: %v", table.Name, err)
+			}
+			if b_invalid_bytes {
+				sm_row[s_invalid_bytes_column] = stream.invalid.Count()
+			}
 			d.StreamListItem(ctx, sm_row)
+
+			// stop reading once the query LIMIT has been satisfied
+			if d.RowsRemaining(ctx) == 0 {
+				break
+			}
 		}
 		return nil, nil
 	}
 }
 
+// Values of the format option
+const (
+	formatCSV        = "csv"
+	formatJSON       = "json"
+	formatJSONL      = "jsonl"
+	formatParquet    = "parquet"
+	formatXLSX       = "xlsx"
+	formatODS        = "ods"
+	formatXML        = "xml"
+	formatHTML       = "html"
+	formatFixedWidth = "fixed_width"
+)
+
+const (
+	i_buff_max     int64 = 20000000  // 20 MB default for max_bytes
+	i_sample_rows  int   = 1000      // rows read to infer the schema
+	i_detect_bytes int   = 64 * 1024 // bytes inspected to detect the separator
+)
+
+// s_invalid_bytes_column is the metadata column counting the invalid bytes
+// substituted in the data of a query, which is a running count as rows are
+// streamed before the end of the data is read. It is left out when the data
+// has a column of the same name.
+const s_invalid_bytes_column = "_invalid_bytes"
+
+// rowIterator reads the rows of one data format from a data URL
+type rowIterator interface {
+	// Columns lists the column names seen so far, in order
+	Columns() []string
+	// Next returns the next row, or io.EOF once the data is exhausted
+	Next() (map[string]interface{}, error)
+}
+
+// rowStream is an open data URL being read one row at a time
+type rowStream struct {
+	rowIterator
+	body    io.Closer
+	text    *textReader
+	invalid *invalidBytes
+}
 
-func readData(ctx context.Context, s_url string) ([]map[string]string, map[string]string, error) {
-
-	var sa_data []map[string]string
-	// var sa_rows [][] string
-	var sa_columns [] string
-
-	resp, err := http.Get(s_url)
-    if err != nil {
-        plugin.Logger(ctx).Error("readData Error < " + err.Error() + ">")
-    }
-    defer resp.Body.Close()
-
-    var sb_data strings.Builder
-    var i_buff_total int = 0
-    var i_buff_max int = 20000000 // 20 MB
-    var i_read_buff int = 1000000 // 1 MB
-    var b_eof bool = false
-    buff := make([]byte, i_read_buff)  
-    for i_buff_total < i_buff_max {
-        var bytesRead int  
-        bytesRead, err = resp.Body.Read(buff)
-        if err == io.EOF {
-            b_eof = true
-            if bytesRead <= 0 {  
-                break  
-            }
-        }
-        if err != nil {
-        	plugin.Logger(ctx).Error("readData Error < " + err.Error() + " >")
-        }
-        s_data := string(buff[:bytesRead])
-        s_data = strings.Replace(s_data, "\r\n", "\n", -1) // handle DOS/Windows newlines
-        s_data = strings.Replace(s_data, "\r", "\n", -1) // handle DOS/Windows newlines
-        i_buff_total = len(sb_data.String())
-        sb_data.WriteString(sanitizeUTF8(s_data))
-    }
-
-    s_final_data := sb_data.String()
-    if b_eof == false {
-        var i_final_newline int = strings.LastIndex(s_final_data, "\n")
-        s_final_data = s_final_data[0 :i_final_newline]
-    }
-
-    detector := New()
-	sampleLines := 4
-	detector.Configure(&sampleLines, nil)
-	delimiters := detector.DetectDelimiter(strings.NewReader(s_final_data), '"')
-
-    nr := csv.NewReader(strings.NewReader(s_final_data))
-    s_separator := strings.Replace(delimiters[0], "/", "//", -1)
-    nr.Comma = GetSeparator(s_separator)
-
-	records, err := nr.ReadAll()
+// openRows :: fetch the table URL, transcode text to UTF-8 and start
+// reading rows with the decoder of its format, sniffing the format from the
+// data when it is not known
+func openRows(ctx context.Context, table tableConfig) (*rowStream, error) {
+
+	spec, err := tableFormat(table)
+	if err != nil {
+		return nil, err
+	}
+	s_policy, err := maxBytesPolicy(table)
 	if err != nil {
-		plugin.Logger(ctx).Error(err.Error())
+		return nil, err
+	}
+	invalid, err := newInvalidBytes(table)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := openURL(ctx, table)
+	if err != nil {
+		plugin.Logger(ctx).Error("openRows Error < " + err.Error() + " >")
+		return nil, err
+	}
+	if spec == nil {
+		// binary formats are recognised before any transcoding
+		var head []byte
+		body, head = peekData(body, i_sniff_bytes)
+		if sniffed := sniffFormat(table, body, head); !sniffed.text {
+			spec = sniffed
+		}
+	}
+	if spec == nil || spec.text {
+		// text is made UTF-8 before its format is sniffed or parsed
+		if body, err = decodeText(ctx, table, body, invalid); err != nil {
+			return nil, err
+		}
+	}
+	if spec == nil {
+		var head []byte
+		body, head = peekData(body, i_sniff_bytes)
+		spec = sniffFormat(table, body, head)
+		plugin.Logger(ctx).Debug("detected data format", "table", table.Name, "format", spec.name)
+	}
+	if _, ok := spec.decoder.(rangeDecoder); ok {
+		body.Close()
+		return nil, fmt.Errorf("table %s: %s holds %s data, which is read by range requests: set format = %q, outside of any archive", table.Name, body.URL, spec.name, spec.name)
 	}
 
-	for _, s_value := range records[0] {
-		sa_columns = append(sa_columns, s_value)
+	stream := &rowStream{body: body, invalid: invalid}
+	var r io.Reader = body
+	if spec.text {
+		var s_comment string
+		if table.Comment != nil && spec.comments {
+			s_comment = *table.Comment
+		}
+		stream.text = newTextReader(body, s_comment, spec.lines, maxBytes(table), s_policy, invalid)
+		r = stream.text
+	}
+	rows, err := spec.decoder.Rows(ctx, table, r, body.URL)
+	if err != nil {
+		stream.Close()
+		if stream.text != nil && stream.text.Truncated() {
+			return nil, fmt.Errorf("table %s: %s is larger than max_bytes of %d, which cuts it before its first row: %v", table.Name, body.URL, stream.text.maxBytes, err)
+		}
+		return nil, err
 	}
+	stream.rowIterator = rows
+	return stream, nil
+}
+
+// Next :: the next row, ending the rows at the cut when a document
+// truncated at max_bytes leaves the row after it incomplete
+func (stream *rowStream) Next() (map[string]interface{}, error) {
+	sm_row, err := stream.rowIterator.Next()
+	if err != nil && err != io.EOF && stream.text != nil && stream.text.Truncated() {
+		return nil, io.EOF
+	}
+	return sm_row, err
+}
+
+func (stream *rowStream) Close() error {
+	return stream.body.Close()
+}
+
+// logExceeded :: warn when the data was larger than max_bytes, so that
+// partial results can be told apart from complete ones in the log
+func (stream *rowStream) logExceeded(ctx context.Context, table tableConfig) {
+	if stream.text == nil || !stream.text.Exceeded() {
+		return
+	}
+	if stream.text.policy == maxBytesWarn {
+		plugin.Logger(ctx).Warn("data is larger than max_bytes", "table", table.Name, "max_bytes", stream.text.maxBytes, "bytes_read", stream.text.BytesRead())
+		return
+	}
+	plugin.Logger(ctx).Warn("data truncated at max_bytes", "table", table.Name, "max_bytes", stream.text.maxBytes, "bytes_read", stream.text.BytesRead())
+}
+
+func init() {
+	registerFormat(formatSpec{
+		name:       formatCSV,
+		mimeTypes:  []string{"text/csv", "application/csv", "text/tab-separated-values"},
+		extensions: []string{".csv", ".tsv", ".tab", ".psv"},
+		text:       true,
+		lines:      true,
+		comments:   true,
+		decoder: decoderFunc(func(ctx context.Context, table tableConfig, r io.Reader, s_url string) (rowIterator, error) {
+			return newCSVRows(ctx, table, r, s_url)
+		}),
+	})
+}
+
+// csvRows reads delimited text
+type csvRows struct {
+	reader  *csv.Reader
+	columns []string
+	first   []string // first data row of a file without a header
+}
+
+// newCSVRows :: prepare a CSV reader over the data, detecting the separator
+// and consuming the header row if there is one
+func newCSVRows(ctx context.Context, table tableConfig, r io.Reader, s_url string) (*csvRows, error) {
+
+	s_header_mode, err := headerMode(table)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &csvRows{}
+	br := bufio.NewReaderSize(r, i_detect_bytes)
+
+	var r_separator rune
+	if table.Separator != nil && *table.Separator != "" {
+		r_separator, err = parseSeparator(*table.Separator)
+	} else {
+		// only look at complete lines at the start of the data
+		sample, _ := br.Peek(i_detect_bytes)
+		if i_newline := bytes.LastIndexByte(sample, '\n'); i_newline >= 0 && len(sample) == i_detect_bytes {
+			sample = sample[:i_newline]
+		}
+		detector := New()
+		sampleLines := 4
+		detector.Configure(&sampleLines, nil)
+		delimiters := detector.DetectDelimiter(bytes.NewReader(sample), '"')
+		if len(delimiters) == 0 {
+			err = fmt.Errorf("could not detect a separator for %s, set the separator option, or format = \"fixed_width\" for data in aligned columns", s_url)
+		} else {
+			r_separator, err = parseSeparator(strings.Replace(delimiters[0], "/", "//", -1))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows.reader = csv.NewReader(br)
+	rows.reader.Comma = r_separator
+	rows.reader.FieldsPerRecord = -1
+
+	sa_first, err := rows.reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("no rows read from %s", s_url)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hasHeader(sa_first, s_header_mode) {
+		if ok, s_message := validHeader(ctx, sa_first); !ok {
+			return nil, fmt.Errorf("%s: %s", s_url, s_message)
+		}
+		rows.columns = sa_first
+	} else {
+		rows.columns = generatedColumns(len(sa_first))
+		rows.first = sa_first
+	}
+
+	return rows, nil
+}
+
+func (rows *csvRows) Columns() []string {
+	return rows.columns
+}
+
+// Next maps the next record onto the column names, ignoring surplus fields
+func (rows *csvRows) Next() (map[string]interface{}, error) {
+	sa_record := rows.first
+	rows.first = nil
+	if sa_record == nil {
+		var err error
+		if sa_record, err = rows.reader.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	sm_row := map[string]interface{}{}
+	for idx, s_value := range sa_record {
+		if idx < len(rows.columns) {
+			sm_row[rows.columns[idx]] = s_value
+		}
+	}
+	return sm_row, nil
+}
+
+// readSchema :: read a sample of the table URL and return its column names,
+// in file order, and a map of column name to inferred type
+func readSchema(ctx context.Context, table tableConfig) ([]string, map[string]string, error) {
+
+	// formats read by range requests describe their own schema
+	spec, err := tableFormat(table)
+	if err != nil {
+		return nil, nil, err
+	}
+	if spec != nil {
+		if ranged, ok := spec.decoder.(rangeDecoder); ok {
+			return ranged.Schema(ctx, table)
+		}
+	}
+
+	stream, err := openRows(ctx, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stream.Close()
+
+	var rows []map[string]interface{}
+	for len(rows) < i_sample_rows {
+		sm_row, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, sm_row)
+	}
+
+	sa_columns := stream.Columns()
+	if len(sa_columns) == 0 {
+		return nil, nil, fmt.Errorf("no columns found in %s", stringValue(table.DataURL))
+	}
+	return sa_columns, inferTypes(sa_columns, rows), nil
+}
+
+// inferTypes :: pick the narrowest type that fits every sampled value of
+// each column. Text is checked for dates and numbers, falling back to
+// STRING. Values decoded from typed formats keep their own type, and nested
+// or mixed values make a JSON column.
+func inferTypes(sa_columns []string, rows []map[string]interface{}) map[string]string {
 
 	sa_column_map := make(map[string]string)
-	for idx, s_column := range sa_columns {
+	for _, s_column := range sa_columns {
 		i_false_date := 0
 		i_false_integer := 0
 		i_false_numeric := 0
-		s_data_type := "STRING"
-		for idx0, sa_row := range records {
-			if idx0 == 0 {
-				continue;
-			}
-			s_value := sa_row[idx]
-			if !isDate(s_value) {
-				i_false_date++
-			}
-			if !isInteger(s_value) {
-				i_false_integer++
+		sm_kinds := map[string]bool{}
+		for _, sm_row := range rows {
+			value, ok := sm_row[s_column]
+			if !ok || value == nil {
+				continue
 			}
-			if !isNumeric(s_value) {
-				i_false_numeric++
-			}
-			if i_false_date > 0 && i_false_integer > 0 && i_false_numeric > 0 {
-				break
+			switch v := value.(type) {
+			case string:
+				if !isDate(v) {
+					i_false_date++
+				}
+				if !isInteger(v) {
+					i_false_integer++
+				}
+				if !isNumeric(v) {
+					i_false_numeric++
+				}
+				sm_kinds["STRING"] = true
+			case bool:
+				sm_kinds["BOOLEAN"] = true
+			case int, int32, int64:
+				sm_kinds["INTEGER"] = true
+			case float32, float64:
+				sm_kinds["NUMERIC"] = true
+			case time.Time:
+				sm_kinds["DATE"] = true
+			default:
+				sm_kinds["JSON"] = true
 			}
 		}
-		if i_false_date == 0 {
+
+		s_data_type := "STRING"
+		if len(sm_kinds) == 2 && sm_kinds["INTEGER"] && sm_kinds["NUMERIC"] {
+			s_data_type = "NUMERIC"
+		} else if len(sm_kinds) > 1 {
+			s_data_type = "JSON"
+		} else if len(sm_kinds) == 1 && !sm_kinds["STRING"] {
+			for s_kind := range sm_kinds {
+				s_data_type = s_kind
+			}
+		} else if len(sm_kinds) == 0 {
+			s_data_type = "STRING"
+		} else if i_false_date == 0 {
 			s_data_type = "DATE"
 		} else if i_false_integer == 0 {
 			s_data_type = "INTEGER"
 		} else if i_false_numeric == 0 {
 			s_data_type = "NUMERIC"
 		}
-		
+
 		sa_column_map[s_column] = s_data_type
 	}
 
-	for idx, record := range records {
-		if idx == 0 {
-			continue
-		}
-		sm_row := map[string]string{}
-		for idx0, s_value := range record {
-			sm_row[sa_columns[idx0]] = s_value
-		}
-		sa_data = append(sa_data, sm_row)
+	return sa_column_map
+}
+
+// headerMode :: validate the header option, which defaults to auto
+func headerMode(table tableConfig) (string, error) {
+	if table.Header == nil || *table.Header == "" {
+		return "auto", nil
+	}
+	s_mode := strings.ToLower(*table.Header)
+	switch s_mode {
+	case "auto", "true", "false":
+		return s_mode, nil
 	}
+	return "", fmt.Errorf("table %s: header must be one of auto, true or false, got %q", table.Name, *table.Header)
+}
 
-	return sa_data, sa_column_map, nil
+// maxBytes :: the max_bytes option, which defaults to 20 MB
+func maxBytes(table tableConfig) int64 {
+	if table.MaxBytes != nil {
+		return *table.MaxBytes
+	}
+	return i_buff_max
+}
 
+// maxBytesPolicy :: validate the max_bytes_policy option, which defaults to truncate
+func maxBytesPolicy(table tableConfig) (string, error) {
+	if table.MaxBytesPolicy == nil || *table.MaxBytesPolicy == "" {
+		return maxBytesTruncate, nil
+	}
+	s_policy := strings.ToLower(*table.MaxBytesPolicy)
+	switch s_policy {
+	case maxBytesTruncate, maxBytesError, maxBytesWarn:
+		return s_policy, nil
+	}
+	return "", fmt.Errorf("table %s: max_bytes_policy must be one of truncate, error or warn, got %q", table.Name, *table.MaxBytesPolicy)
+}
+
+// hasHeader :: decide whether the first record is a header row. In auto mode
+// the first record is taken as a header when it is a valid header and none of
+// its values look like data (numbers or dates).
+func hasHeader(sa_first []string, s_mode string) bool {
+	switch s_mode {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if ok, _ := validHeader(context.Background(), sa_first); !ok {
+		return false
+	}
+	for _, s_value := range sa_first {
+		if isNumeric(s_value) || isDate(s_value) {
+			return false
+		}
+	}
+	return true
+}
+
+// generatedColumns :: column names for files without a header row
+func generatedColumns(i_count int) []string {
+	sa_columns := make([]string, i_count)
+	for idx := range sa_columns {
+		sa_columns[idx] = fmt.Sprintf("column_%d", idx+1)
+	}
+	return sa_columns
 }
 
 // A valid header row has no empty values or duplicate values
@@ -0,0 +1,191 @@
+package url
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// What to do once a data URL holds more than max_bytes
+const (
+	maxBytesTruncate = "truncate" // stop at the last complete line
+	maxBytesError    = "error"    // fail the query
+	maxBytesWarn     = "warn"     // keep reading, the caller logs a warning
+)
+
+// i_chunk_bytes is the most read at once from data that is not made of lines
+const i_chunk_bytes = 64 * 1024
+
+// textReader normalizes a raw data stream before it reaches the CSV parser.
+// DOS and old Mac line endings become "\n", invalid UTF-8 is handled by the
+// invalid_byte_policy, lines starting with the comment prefix are skipped
+// and maxBytes is enforced a line at a time according to policy. Data that
+// is not made of lines, such as a JSON document, is read in chunks instead
+// and truncated at maxBytes exactly.
+type textReader struct {
+	source    *bufio.Reader
+	comment   string
+	lines     bool  // rows are lines, so truncation stops at a line end
+	maxBytes  int64 // 0 or less reads everything
+	policy    string
+	invalid   *invalidBytes
+	bytesRead int64
+	exceeded  bool
+	pending   string
+	err       error
+}
+
+func newTextReader(source io.Reader, comment string, lines bool, maxBytes int64, policy string, invalid *invalidBytes) *textReader {
+	return &textReader{
+		source:   bufio.NewReaderSize(source, 64*1024),
+		comment:  comment,
+		lines:    lines,
+		maxBytes: maxBytes,
+		policy:   policy,
+		invalid:  invalid,
+	}
+}
+
+// Exceeded reports whether the data held more than maxBytes. With the
+// truncate policy this means the rows read stop short of the full data.
+func (r *textReader) Exceeded() bool {
+	return r.exceeded
+}
+
+// Truncated reports whether the data was cut at maxBytes in the middle of
+// a document, which its decoder then finds incomplete.
+func (r *textReader) Truncated() bool {
+	return r.exceeded && !r.lines && r.policy == maxBytesTruncate
+}
+
+// BytesRead is the number of source bytes handed on to the caller.
+func (r *textReader) BytesRead() int64 {
+	return r.bytesRead
+}
+
+// implement the io.Reader interface
+func (r *textReader) Read(p []byte) (n int, err error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.nextLine()
+	}
+
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// nextLine reads one line from the source into pending, applying the
+// newline, comment and size rules.
+func (r *textReader) nextLine() {
+	i_max_line := 0
+	if !r.lines {
+		i_max_line = i_chunk_bytes
+	}
+	line, err := r.readLine(i_max_line)
+	if err != nil {
+		r.err = err
+	}
+	if len(line) == 0 {
+		return
+	}
+
+	if r.maxBytes > 0 && !r.exceeded && r.bytesRead+int64(len(line)) > r.maxBytes {
+		r.exceeded = true
+		switch r.policy {
+		case maxBytesWarn:
+			// keep reading
+		case maxBytesError:
+			r.err = fmt.Errorf("data is larger than max_bytes of %d, %d bytes read", r.maxBytes, r.bytesRead)
+			return
+		default:
+			r.err = io.EOF
+			if r.lines {
+				return
+			}
+			// a document is cut at the limit, on a character boundary
+			line = line[:runeStart(line, int(r.maxBytes-r.bytesRead))]
+		}
+	}
+	i_offset := r.bytesRead
+	r.bytesRead += int64(len(line))
+
+	line, err = r.invalid.sanitize(line)
+	if err != nil {
+		r.err = fmt.Errorf("%v in the line at byte %d, set invalid_byte_policy to read past it", err, i_offset)
+		return
+	}
+	line = strings.Replace(line, "\r\n", "\n", -1) // handle DOS/Windows newlines
+	line = strings.Replace(line, "\r", "\n", -1)   // handle old Mac newlines
+
+	if r.comment == "" {
+		r.pending = line
+		return
+	}
+	// a lone "\r" may have turned one source line into several
+	var sb strings.Builder
+	for _, s := range strings.SplitAfter(line, "\n") {
+		if !strings.HasPrefix(s, r.comment) {
+			sb.WriteString(s)
+		}
+	}
+	r.pending = sb.String()
+}
+
+// readLine reads the next line from the source with its line ending, which
+// is "\n", "\r\n" or a lone "\r", so that text with old Mac line endings
+// is not read as a single line. A line longer than i_max bytes, unless it
+// is 0, is returned in pieces split between characters.
+func (r *textReader) readLine(i_max int) (string, error) {
+	var sb strings.Builder
+	for {
+		// whatever is buffered, reading more once it is used up
+		buff, err := r.source.Peek(max(r.source.Buffered(), 1))
+		if len(buff) == 0 {
+			return sb.String(), err
+		}
+		i_end := bytes.IndexAny(buff, "\r\n")
+		if i_max > 0 && sb.Len()+len(buff) >= i_max && (i_end < 0 || sb.Len()+i_end >= i_max) {
+			i_cut := runeStart(string(buff), i_max-sb.Len())
+			if sb.Len()+i_cut == 0 {
+				i_cut = i_max
+			}
+			sb.Write(buff[:i_cut])
+			r.source.Discard(i_cut)
+			return sb.String(), nil
+		}
+		if i_end < 0 {
+			sb.Write(buff)
+			r.source.Discard(len(buff))
+			continue
+		}
+		sb.Write(buff[:i_end+1])
+		r.source.Discard(i_end + 1)
+		if buff[i_end] == '\r' {
+			if next, _ := r.source.Peek(1); len(next) == 1 && next[0] == '\n' {
+				sb.WriteByte('\n')
+				r.source.Discard(1)
+			}
+		}
+		return sb.String(), nil
+	}
+}
+
+// runeStart :: the largest offset up to i in s that does not split a UTF-8
+// character, looking back no further than a character is long
+func runeStart(s string, i int) int {
+	if i >= len(s) {
+		return len(s)
+	}
+	for i_back := i; i_back > 0 && i-i_back < utf8.UTFMax; i_back-- {
+		if utf8.RuneStart(s[i_back]) {
+			return i_back
+		}
+	}
+	return i
+}
@@ -1,9 +1,10 @@
 package url
 
 import (
-	"strconv"
+	"fmt"
 	"regexp"
-	"strings"
+	"strconv"
+	"unicode/utf8"
 )
 
 func isInteger(s string) bool {
@@ -27,24 +28,25 @@ func isDate(s string) bool {
 	return match
 }
 
-func sanitizeUTF8(s string) string {
-    var b strings.Builder
-    for _, c := range s {
-        if c == '\uFFFD' {
-            continue
-        }
-        b.WriteRune(c)
-    }
-    return b.String()
+// GetSeparator :: like parseSeparator, returning 0 for an invalid separator
+func GetSeparator(s string) rune {
+	sep, _ := parseSeparator(s)
+	return sep
 }
 
-func GetSeparator(s string) rune {
-	var sep string
-	s = `'` + s + `'`
-	sep, _ = strconv.Unquote(s) // please handle the err properly
-	return ([]rune(sep))[0]
+// parseSeparator :: resolve a separator such as "," or "\t" to a single rune
+func parseSeparator(s string) (rune, error) {
+	sep, err := strconv.Unquote(`'` + s + `'`)
+	if err == nil && sep != "" {
+		return ([]rune(sep))[0], nil
+	}
+	if utf8.RuneCountInString(s) == 1 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return r, nil
+	}
+	return 0, fmt.Errorf("invalid separator %q, it must be a single character", s)
 }
 
 func GetSeparatorx(s string) string {
 	return s
-}
\ No newline at end of file
+}